@@ -0,0 +1,67 @@
+package rapidyenc
+
+// crc32IEEEReversed is the reversed IEEE polynomial used by yEnc crc32 and pcrc32 values.
+const crc32IEEEReversed = 0xedb88320
+
+// CombineCRC32 returns the CRC32 (IEEE) of the concatenation of two blocks of
+// data, given crc1 of the first block and crc2 of the second block of len2 bytes.
+//
+// This permits computing the whole file "crc32=" from the "pcrc32=" of each part
+// without access to the data, for example when parts are encoded in parallel.
+func CombineCRC32(crc1, crc2 uint32, len2 int64) uint32 {
+	if len2 <= 0 {
+		return crc1
+	}
+
+	// Operators for appending zero bits to a CRC, as in zlib's crc32_combine
+	var even, odd [32]uint32
+
+	odd[0] = crc32IEEEReversed
+	row := uint32(1)
+	for n := 1; n < 32; n++ {
+		odd[n] = row
+		row <<= 1
+	}
+
+	gf2MatrixSquare(&even, &odd) // 2 zero bits
+	gf2MatrixSquare(&odd, &even) // 4 zero bits
+
+	// Apply len2 zero bytes to crc1, the first square gives 1 zero byte
+	for {
+		gf2MatrixSquare(&even, &odd)
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(&even, crc1)
+		}
+		len2 >>= 1
+		if len2 == 0 {
+			break
+		}
+
+		gf2MatrixSquare(&odd, &even)
+		if len2&1 != 0 {
+			crc1 = gf2MatrixTimes(&odd, crc1)
+		}
+		len2 >>= 1
+		if len2 == 0 {
+			break
+		}
+	}
+
+	return crc1 ^ crc2
+}
+
+func gf2MatrixTimes(mat *[32]uint32, vec uint32) uint32 {
+	var sum uint32
+	for i := 0; vec != 0; i, vec = i+1, vec>>1 {
+		if vec&1 != 0 {
+			sum ^= mat[i]
+		}
+	}
+	return sum
+}
+
+func gf2MatrixSquare(square, mat *[32]uint32) {
+	for n := range mat {
+		square[n] = gf2MatrixTimes(mat, mat[n])
+	}
+}
@@ -0,0 +1,21 @@
+package rapidyenc
+
+import (
+	"crypto/rand"
+	"hash/crc32"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCombineCRC32(t *testing.T) {
+	raw := make([]byte, 100_000)
+	_, err := rand.Read(raw)
+	require.NoError(t, err)
+
+	for _, split := range []int{0, 1, 2, 3, 100, 4096, 99_999, 100_000} {
+		a, b := raw[:split], raw[split:]
+		combined := CombineCRC32(crc32.ChecksumIEEE(a), crc32.ChecksumIEEE(b), int64(len(b)))
+		require.Equal(t, crc32.ChecksumIEEE(raw), combined, "split %d", split)
+	}
+}
@@ -1,12 +1,23 @@
-//go:build amd64
+//go:build amd64 && !purego
 
 package rapidyenc
 
 // AMD64 always has SSE2 (Go requires it).
 var useSIMDDecode = true
 
-// decodeFast subtracts 42 from each byte using SSE2 SIMD.
-// Stops at any special byte (\r, \n, =) and returns the count of clean bytes processed.
-//
+// decodeFast decodes src, starting mid-line, using SIMD. Escapes, line
+// endings and dot-unstuffing are handled in the vector, it stops before
+// anything that may end the body and returns the bytes written and consumed,
+// and the number of \n consumed.
+func decodeFast(dst, src []byte) (nDst, nSrc, lines int) {
+	if useAVX2 {
+		return decodeAVX2(dst, src)
+	}
+	return decodeSSE2(dst, src)
+}
+
+//go:noescape
+func decodeSSE2(dst, src []byte) (nDst, nSrc, lines int)
+
 //go:noescape
-func decodeFast(dst, src []byte) int
+func decodeAVX2(dst, src []byte) (nDst, nSrc, lines int)
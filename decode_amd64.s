@@ -1,79 +1,449 @@
+//go:build !purego
+
 #include "textflag.h"
 
-// func decodeFast(dst, src []byte) int
+// ·laneMask holds 16 masks of 16 bytes, mask k has its first k bytes set.
+// It selects the lanes on either side of a byte removed from or inserted in
+// a vector, by this decoder and the encoder.
+DATA ·laneMask+0x00(SB)/8, $0x0000000000000000
+DATA ·laneMask+0x08(SB)/8, $0x0000000000000000
+DATA ·laneMask+0x10(SB)/8, $0x00000000000000ff
+DATA ·laneMask+0x18(SB)/8, $0x0000000000000000
+DATA ·laneMask+0x20(SB)/8, $0x000000000000ffff
+DATA ·laneMask+0x28(SB)/8, $0x0000000000000000
+DATA ·laneMask+0x30(SB)/8, $0x0000000000ffffff
+DATA ·laneMask+0x38(SB)/8, $0x0000000000000000
+DATA ·laneMask+0x40(SB)/8, $0x00000000ffffffff
+DATA ·laneMask+0x48(SB)/8, $0x0000000000000000
+DATA ·laneMask+0x50(SB)/8, $0x000000ffffffffff
+DATA ·laneMask+0x58(SB)/8, $0x0000000000000000
+DATA ·laneMask+0x60(SB)/8, $0x0000ffffffffffff
+DATA ·laneMask+0x68(SB)/8, $0x0000000000000000
+DATA ·laneMask+0x70(SB)/8, $0x00ffffffffffffff
+DATA ·laneMask+0x78(SB)/8, $0x0000000000000000
+DATA ·laneMask+0x80(SB)/8, $0xffffffffffffffff
+DATA ·laneMask+0x88(SB)/8, $0x0000000000000000
+DATA ·laneMask+0x90(SB)/8, $0xffffffffffffffff
+DATA ·laneMask+0x98(SB)/8, $0x00000000000000ff
+DATA ·laneMask+0xa0(SB)/8, $0xffffffffffffffff
+DATA ·laneMask+0xa8(SB)/8, $0x000000000000ffff
+DATA ·laneMask+0xb0(SB)/8, $0xffffffffffffffff
+DATA ·laneMask+0xb8(SB)/8, $0x0000000000ffffff
+DATA ·laneMask+0xc0(SB)/8, $0xffffffffffffffff
+DATA ·laneMask+0xc8(SB)/8, $0x00000000ffffffff
+DATA ·laneMask+0xd0(SB)/8, $0xffffffffffffffff
+DATA ·laneMask+0xd8(SB)/8, $0x000000ffffffffff
+DATA ·laneMask+0xe0(SB)/8, $0xffffffffffffffff
+DATA ·laneMask+0xe8(SB)/8, $0x0000ffffffffffff
+DATA ·laneMask+0xf0(SB)/8, $0xffffffffffffffff
+DATA ·laneMask+0xf8(SB)/8, $0x00ffffffffffffff
+GLOBL ·laneMask(SB), RODATA|NOPTR, $256
+
+// func decodeSSE2(dst, src []byte) (nDst, nSrc, lines int)
+//
+// SSE2 yEnc decoder for the body of a line, called with src starting
+// mid-line. Each 16-byte chunk is classified with compare masks:
+//
+//   - escapes ("=" followed by any byte but \r, \n or "=") are decoded in
+//     the vector by taking the following byte less 106 in place of the "="
+//   - \r and \n are dropped, a "." starting a line is dropped (dot-unstuffing)
+//   - the dropped lanes are squeezed out one at a time, highest first, by
+//     shifting the lanes above them down with a mask from ·laneMask,
+//     SSE2 has no byte shuffle
+//
+// A chunk containing "\n=y", "\n.\r", "\n.\n", "\n.=", an escaped \r, \n or
+// "=" is left to the scalar state machine in decodeGeneric: the bytes before
+// its first special byte are decoded and the function returns. It also
+// returns when fewer than 18 bytes are left, a chunk looks ahead 2 bytes.
 //
-// SSE2 yEnc decoder: subtracts 42 from each byte in 16-byte SIMD chunks.
-// Stops at any special byte (\r, \n, =) and returns the count of
-// clean bytes decoded. Uses partial-chunk optimization: when a special
-// is found mid-chunk, stores the clean prefix before it.
+// The decoded chunk is stored whole, dst may alias src as it never overtakes
+// the chunk being read. The \n lanes are counted in lines.
 //
 // Register allocation:
-//   DI = dst write pointer (advances)
-//   SI = src read pointer (advances)
-//   BX = remaining src bytes (decrements)
-//   R10 = bytes processed accumulator
-//   AX, CX, DX, R8 = temporaries
-//   X0 = data, X1-X3 = comparisons
-//   X4 = splat(\r), X5 = splat(\n), X6 = splat(=), X7 = splat(42)
-TEXT ·decodeFast(SB), NOSPLIT, $0-56
+//   DI = dst write pointer, SI = src read pointer, BX = remaining src bytes
+//   R12, R13 = dst and src start, R14 = ·laneMask
+//   AX, CX, DX = \r, \n and = masks of the chunk
+//   R8-R11 = temporaries
+//   X0 = chunk, X1 = chunk shifted by 1 byte, X2 = chunk shifted by 2 bytes
+//   X3-X7 = temporaries
+//   X8 = splat(\r), X9 = splat(\n), X10 = splat(=), X11 = splat(42)
+//   X12 = splat(.), X13 = splat(y), X14 = splat(106)
+TEXT ·decodeSSE2(SB), NOSPLIT, $0-72
 	MOVQ dst_base+0(FP), DI
 	MOVQ src_base+24(FP), SI
 	MOVQ src_len+32(FP), BX
-	XORQ R10, R10                // n = 0
+	MOVQ DI, R12
+	MOVQ SI, R13
+	LEAQ ·laneMask(SB), R14
+	MOVQ $0, lines+64(FP)
 
 	// Splat constant vectors
 	MOVQ $0x0D0D0D0D0D0D0D0D, DX
-	MOVQ DX, X4
-	PUNPCKLQDQ X4, X4
+	MOVQ DX, X8
+	PUNPCKLQDQ X8, X8
 	MOVQ $0x0A0A0A0A0A0A0A0A, DX
-	MOVQ DX, X5
-	PUNPCKLQDQ X5, X5
+	MOVQ DX, X9
+	PUNPCKLQDQ X9, X9
 	MOVQ $0x3D3D3D3D3D3D3D3D, DX
-	MOVQ DX, X6
-	PUNPCKLQDQ X6, X6
+	MOVQ DX, X10
+	PUNPCKLQDQ X10, X10
 	MOVQ $0x2A2A2A2A2A2A2A2A, DX
-	MOVQ DX, X7
-	PUNPCKLQDQ X7, X7
+	MOVQ DX, X11
+	PUNPCKLQDQ X11, X11
+	MOVQ $0x2E2E2E2E2E2E2E2E, DX
+	MOVQ DX, X12
+	PUNPCKLQDQ X12, X12
+	MOVQ $0x7979797979797979, DX
+	MOVQ DX, X13
+	PUNPCKLQDQ X13, X13
+	MOVQ $0x6A6A6A6A6A6A6A6A, DX
+	MOVQ DX, X14
+	PUNPCKLQDQ X14, X14
 
 simd_loop:
-	CMPQ BX, $16
+	CMPQ BX, $18
 	JLT  byte_tail
 
 	MOVOU (SI), X0               // load 16 src bytes
 
 	// Compare against special characters
-	MOVOU X0, X1
-	PCMPEQB X4, X1              // X1 = (byte == \r)
-	MOVOU X0, X2
-	PCMPEQB X5, X2              // X2 = (byte == \n)
 	MOVOU X0, X3
-	PCMPEQB X6, X3              // X3 = (byte == =)
+	PCMPEQB X8, X3
+	PMOVMSKB X3, AX              // AX = (byte == \r)
+	MOVOU X0, X3
+	PCMPEQB X9, X3
+	PMOVMSKB X3, CX              // CX = (byte == \n)
+	MOVOU X0, X5
+	PCMPEQB X10, X5
+	PMOVMSKB X5, DX              // DX = (byte == =), X5 keeps the vector mask
 
-	// Combine all specials: \r | \n | =
-	POR  X2, X1
-	POR  X3, X1
-	PMOVMSKB X1, AX
-	TESTL AX, AX
+	MOVL AX, R8
+	ORL  CX, R8
+	ORL  DX, R8
 	JNZ  has_specials
 
 	// === Fast path: no specials ===
-	PSUBB X7, X0
+	PSUBB X11, X0
 	MOVOU X0, (DI)
 	ADDQ $16, DI
 	ADDQ $16, SI
-	ADDQ $16, R10
 	SUBQ $16, BX
 	JMP  simd_loop
 
 has_specials:
-	// Special byte found in this chunk.
-	// Fall through to byte_tail to process bytes one at a time.
-	// We do NOT store a partial 16-byte SIMD result because when
-	// dst and src share the same buffer (in-place decoding), the
-	// extra bytes past the clean prefix would corrupt unread source data.
+	// "==" never comes from an encoder, the second "=" would be escaped
+	MOVL DX, R8
+	SHLL $1, R8
+	TESTL DX, R8
+	JNZ  byte_tail
+
+	MOVOU 1(SI), X1              // X1[i] = byte after X0[i]
+	MOVOU 2(SI), X2              // X2[i] = byte after X1[i]
+
+	// An escaped \r or \n is handled as a line ending by decodeGeneric
+	MOVOU X1, X3
+	PCMPEQB X8, X3
+	MOVOU X1, X4
+	PCMPEQB X9, X4
+	POR  X4, X3
+	PMOVMSKB X3, R8
+	TESTL DX, R8
+	JNZ  byte_tail
+
+	// Bytes starting a line, after \n
+	XORL R10, R10                // R10 = \n followed by a "." to drop
+	TESTL CX, CX
+	JZ   decode_chunk
+
+	// "\n=y" ends the body
+	MOVOU X1, X3
+	PCMPEQB X10, X3
+	MOVOU X2, X4
+	PCMPEQB X13, X4
+	PAND X4, X3
+	PMOVMSKB X3, R8
+	TESTL CX, R8
+	JNZ  byte_tail
+
+	MOVOU X1, X3
+	PCMPEQB X12, X3
+	PMOVMSKB X3, R10
+	ANDL CX, R10
+	JZ   decode_chunk
+
+	// "\n.\r", "\n.\n" and "\n.=" may end the article
+	MOVOU X2, X3
+	PCMPEQB X8, X3
+	MOVOU X2, X4
+	PCMPEQB X9, X4
+	POR  X4, X3
+	MOVOU X2, X4
+	PCMPEQB X10, X4
+	POR  X4, X3
+	PMOVMSKB X3, R8
+	TESTL R10, R8
+	JNZ  byte_tail
+
+decode_chunk:
+	// Escaped lanes take the following byte less 106, others the byte less 42
+	PSUBB X11, X0
+	MOVOU X1, X3
+	PSUBB X14, X3
+	PAND X5, X3
+	PANDN X0, X5
+	POR  X5, X3
+	MOVOU X3, X0
+
+	// Lanes to drop: \r, \n, escaped bytes and unstuffed dots.
+	// Bit 16 is the byte after the chunk, consumed by an escape or a dot.
+	MOVL DX, R9
+	ORL  R10, R9
+	SHLL $1, R9
+	ORL  AX, R9
+	ORL  CX, R9
+	MOVL R9, R11
+	SHRL $16, R11
+	ADDQ $16, R11                // R11 = src bytes consumed
+	ANDL $0xFFFF, R9
+
+	// Count the \n lanes, one at a time as there are few
+count_lines:
+	TESTL CX, CX
+	JZ   counted
+	LEAL -1(CX), R8
+	ANDL R8, CX
+	INCQ lines+64(FP)
+	JMP  count_lines
+
+counted:
+	MOVQ $16, R8                 // R8 = dst bytes produced
+
+compact:
+	TESTL R9, R9
+	JZ   store
+	BSRL R9, CX
+	BTRL CX, R9
+	DECQ R8
+	SHLQ $4, CX
+	MOVOU (R14)(CX*1), X6        // lanes below the dropped lane
+	MOVOU X0, X7
+	PSRLDQ $1, X7
+	PAND X6, X0
+	PANDN X7, X6
+	POR  X6, X0
+	JMP  compact
+
+store:
+	MOVOU X0, (DI)
+	ADDQ R8, DI
+	ADDQ R11, SI
+	SUBQ R11, BX
+	JMP  simd_loop
+
+byte_tail:
+	// Decode up to the next special byte, for decodeGeneric to handle
+	TESTQ BX, BX
+	JZ   done
+	MOVBLZX (SI), AX
+	CMPL AX, $13
+	JEQ  done
+	CMPL AX, $10
+	JEQ  done
+	CMPL AX, $61
+	JEQ  done
+
+	SUBL $42, AX
+	MOVB AL, (DI)
+	INCQ DI
+	INCQ SI
+	DECQ BX
 	JMP  byte_tail
 
+done:
+	SUBQ R12, DI
+	SUBQ R13, SI
+	MOVQ DI, nDst+48(FP)
+	MOVQ SI, nSrc+56(FP)
+	RET
+
+// COMPACT16 stores the lanes of X kept by the 16-bit drop mask in M at DI
+// and advances DI past them. Each 8-lane half is gathered with PSHUFB from
+// ·decodeShuffle, the high half is stored right after the kept low lanes.
+// Clobbers AX, CX, X6 and X7.
+#define COMPACT16(X, M) \
+	MOVL        M, AX;               \
+	ANDL        $0xFF, AX;           \
+	MOVL        M, CX;               \
+	SHRL        $8, CX;              \
+	ANDL        $0xFF, CX;           \
+	VMOVQ       (R14)(AX*8), X6;     \
+	VMOVQ       (R14)(CX*8), X7;     \
+	VPUNPCKLQDQ X7, X6, X6;          \
+	VPADDB      X15, X6, X6;         \
+	VPSHUFB     X6, X, X6;           \
+	VMOVQ       X6, (DI);            \
+	POPCNTL     AX, AX;              \
+	NEGQ        AX;                  \
+	ADDQ        $8, AX;              \
+	VMOVHPS     X6, (DI)(AX*1);      \
+	POPCNTL     CX, CX;              \
+	SUBQ        CX, AX;              \
+	LEAQ        8(DI)(AX*1), DI
+
+// func decodeAVX2(dst, src []byte) (nDst, nSrc, lines int)
+//
+// AVX2 version of decodeSSE2 working on 32-byte chunks, see there for how a
+// chunk is decoded and when it returns. The dropped lanes are squeezed out
+// with PSHUFB, 8 lanes at a time. It returns when fewer than 34 bytes are
+// left.
+//
+// Register allocation:
+//   DI = dst write pointer, SI = src read pointer, BX = remaining src bytes
+//   R12, R13 = dst and src start, R14 = ·decodeShuffle
+//   AX, CX, DX = \r, \n and = masks of the chunk
+//   R8-R11 = temporaries
+//   Y0 = chunk, Y1 = chunk shifted by 1 byte, Y2 = chunk shifted by 2 bytes
+//   Y3-Y7 = temporaries
+//   Y8 = splat(\r), Y9 = splat(\n), Y10 = splat(=), Y11 = splat(42)
+//   Y12 = splat(.), Y13 = splat(y), Y14 = splat(106)
+//   X15 = 0 in the low 8 lanes and 8 in the high ones, offsets for PSHUFB
+TEXT ·decodeAVX2(SB), NOSPLIT, $0-72
+	MOVQ dst_base+0(FP), DI
+	MOVQ src_base+24(FP), SI
+	MOVQ src_len+32(FP), BX
+	MOVQ DI, R12
+	MOVQ SI, R13
+	LEAQ ·decodeShuffle(SB), R14
+	MOVQ $0, lines+64(FP)
+
+	// Splat constant vectors
+	MOVQ $0x0D0D0D0D0D0D0D0D, DX
+	MOVQ DX, X8
+	VPBROADCASTQ X8, Y8
+	MOVQ $0x0A0A0A0A0A0A0A0A, DX
+	MOVQ DX, X9
+	VPBROADCASTQ X9, Y9
+	MOVQ $0x3D3D3D3D3D3D3D3D, DX
+	MOVQ DX, X10
+	VPBROADCASTQ X10, Y10
+	MOVQ $0x2A2A2A2A2A2A2A2A, DX
+	MOVQ DX, X11
+	VPBROADCASTQ X11, Y11
+	MOVQ $0x2E2E2E2E2E2E2E2E, DX
+	MOVQ DX, X12
+	VPBROADCASTQ X12, Y12
+	MOVQ $0x7979797979797979, DX
+	MOVQ DX, X13
+	VPBROADCASTQ X13, Y13
+	MOVQ $0x6A6A6A6A6A6A6A6A, DX
+	MOVQ DX, X14
+	VPBROADCASTQ X14, Y14
+	MOVQ $0x0808080808080808, DX
+	MOVQ DX, X15
+	VPSLLDQ $8, X15, X15
+
+simd_loop:
+	CMPQ BX, $34
+	JLT  byte_tail
+
+	VMOVDQU (SI), Y0             // load 32 src bytes
+
+	// Compare against special characters
+	VPCMPEQB Y8, Y0, Y3
+	VPMOVMSKB Y3, AX             // AX = (byte == \r)
+	VPCMPEQB Y9, Y0, Y3
+	VPMOVMSKB Y3, CX             // CX = (byte == \n)
+	VPCMPEQB Y10, Y0, Y5
+	VPMOVMSKB Y5, DX             // DX = (byte == =), Y5 keeps the vector mask
+
+	MOVL AX, R8
+	ORL  CX, R8
+	ORL  DX, R8
+	JNZ  has_specials
+
+	// === Fast path: no specials ===
+	VPSUBB Y11, Y0, Y0
+	VMOVDQU Y0, (DI)
+	ADDQ $32, DI
+	ADDQ $32, SI
+	SUBQ $32, BX
+	JMP  simd_loop
+
+has_specials:
+	// "==" never comes from an encoder, the second "=" would be escaped
+	MOVL DX, R8
+	SHLL $1, R8
+	TESTL DX, R8
+	JNZ  byte_tail
+
+	VMOVDQU 1(SI), Y1            // Y1[i] = byte after Y0[i]
+	VMOVDQU 2(SI), Y2            // Y2[i] = byte after Y1[i]
+
+	// An escaped \r or \n is handled as a line ending by decodeGeneric
+	VPCMPEQB Y8, Y1, Y3
+	VPCMPEQB Y9, Y1, Y4
+	VPOR Y4, Y3, Y3
+	VPMOVMSKB Y3, R8
+	TESTL DX, R8
+	JNZ  byte_tail
+
+	// Bytes starting a line, after \n
+	XORL R10, R10                // R10 = \n followed by a "." to drop
+	TESTL CX, CX
+	JZ   decode_chunk
+
+	// "\n=y" ends the body
+	VPCMPEQB Y10, Y1, Y3
+	VPCMPEQB Y13, Y2, Y4
+	VPAND Y4, Y3, Y3
+	VPMOVMSKB Y3, R8
+	TESTL CX, R8
+	JNZ  byte_tail
+
+	VPCMPEQB Y12, Y1, Y3
+	VPMOVMSKB Y3, R10
+	ANDL CX, R10
+	JZ   decode_chunk
+
+	// "\n.\r", "\n.\n" and "\n.=" may end the article
+	VPCMPEQB Y8, Y2, Y3
+	VPCMPEQB Y9, Y2, Y4
+	VPOR Y4, Y3, Y3
+	VPCMPEQB Y10, Y2, Y4
+	VPOR Y4, Y3, Y3
+	VPMOVMSKB Y3, R8
+	TESTL R10, R8
+	JNZ  byte_tail
+
+decode_chunk:
+	// Escaped lanes take the following byte less 106, others the byte less 42
+	VPSUBB Y11, Y0, Y0
+	VPSUBB Y14, Y1, Y3
+	VPBLENDVB Y5, Y3, Y0, Y0
+
+	// Lanes to drop: \r, \n, escaped bytes and unstuffed dots.
+	// Bit 32 is the byte after the chunk, consumed by an escape or a dot.
+	MOVL DX, R9
+	ORL  R10, R9
+	SHLQ $1, R9
+	ORQ  AX, R9
+	ORQ  CX, R9
+	MOVQ R9, R11
+	SHRQ $32, R11
+	ADDQ $32, R11                // R11 = src bytes consumed
+	POPCNTL CX, CX
+	ADDQ CX, lines+64(FP)        // count the \n lanes
+
+	VEXTRACTI128 $1, Y0, X1
+	COMPACT16(X0, R9)
+	SHRL $16, R9
+	COMPACT16(X1, R9)
+	ADDQ R11, SI
+	SUBQ R11, BX
+	JMP  simd_loop
+
 byte_tail:
+	// Decode up to the next special byte, for decodeGeneric to handle
 	TESTQ BX, BX
 	JZ   done
 	MOVBLZX (SI), AX
@@ -87,11 +457,14 @@ byte_tail:
 	SUBL $42, AX
 	MOVB AL, (DI)
 	INCQ DI
-	INCQ R10
 	INCQ SI
 	DECQ BX
 	JMP  byte_tail
 
 done:
-	MOVQ R10, ret+48(FP)
+	VZEROUPPER
+	SUBQ R12, DI
+	SUBQ R13, SI
+	MOVQ DI, nDst+48(FP)
+	MOVQ SI, nSrc+56(FP)
 	RET
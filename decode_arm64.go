@@ -1,12 +1,14 @@
-//go:build arm64
+//go:build arm64 && !purego
 
 package rapidyenc
 
 // ARM64 always has NEON (ASIMD), no runtime detection needed.
 var useSIMDDecode = true
 
-// decodeFast subtracts 42 from each byte using NEON SIMD.
-// Stops at any special byte (\r, \n, =) and returns the count of clean bytes processed.
+// decodeFast decodes src, starting mid-line, using NEON SIMD. Escapes, line
+// endings and dot-unstuffing are handled in the vector, it stops before
+// anything that may end the body and returns the bytes written and consumed,
+// and the number of \n consumed.
 //
 //go:noescape
-func decodeFast(dst, src []byte) int
+func decodeFast(dst, src []byte) (nDst, nSrc, lines int)
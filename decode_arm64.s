@@ -1,51 +1,73 @@
+//go:build !purego
+
 #include "textflag.h"
 
-// func decodeFast(dst, src []byte) int
+// ·bitWeights gives each lane its bit in a 16-bit mask, per 8-byte half.
+DATA ·bitWeights+0x00(SB)/8, $0x8040201008040201
+DATA ·bitWeights+0x08(SB)/8, $0x8040201008040201
+GLOBL ·bitWeights(SB), RODATA|NOPTR, $16
+
+// MOVEMASK sets the low 16 bits of R to the lanes of the compare result V
+// that are set, like PMOVMSKB. T is clobbered.
+#define MOVEMASK(V, T, R) \
+	VAND  V31.B16, V.B16, T.B16 \
+	VADDP T.B16, T.B16, T.B16   \
+	VADDP T.B16, T.B16, T.B16   \
+	VADDP T.B16, T.B16, T.B16   \
+	VMOV  T.H[0], R
+
+// func decodeFast(dst, src []byte) (nDst, nSrc, lines int)
 //
-// NEON yEnc decoder: subtracts 42 from each byte in 16-byte SIMD chunks.
-// Stops at any special byte (\r, \n, =) and returns the count of
-// clean bytes decoded. Uses partial-chunk optimization: when a special
-// is found mid-chunk, stores the clean prefix before it.
+// NEON yEnc decoder for the body of a line, called with src starting
+// mid-line. It follows the SSE2 version in decode_amd64.s, see there for
+// how each 16-byte chunk is decoded.
 //
 // Register allocation:
-//   R0 = dst write pointer (advances)
-//   R1 = src read pointer (advances)
-//   R2 = remaining src bytes (decrements)
-//   R4 = bytes processed accumulator
-//   R6-R8 = temporaries
-//   V0 = data, V1-V4 = comparisons
+//   R0 = dst write pointer, R1 = src read pointer, R2 = remaining src bytes
+//   R3, R4 = dst and src start, R14 = ·decodeShuffle, R19 = \n consumed
+//   R6, R7, R11 = \r, \n and = masks of the chunk
+//   R5, R8-R10, R12, R13, R15 = temporaries
+//   V0 = chunk, V1 = chunk shifted by 1 byte, V2 = chunk shifted by 2 bytes
+//   V3-V6 = temporaries
 //   V20 = splat(\r), V21 = splat(\n), V22 = splat(=), V23 = splat(42)
-TEXT ·decodeFast(SB), NOSPLIT, $0-56
+//   V24 = splat(.), V25 = splat(y), V26 = splat(106), V31 = ·bitWeights
+TEXT ·decodeFast(SB), NOSPLIT, $0-72
 	MOVD dst_base+0(FP), R0
 	MOVD src_base+24(FP), R1
 	MOVD src_len+32(FP), R2
-	MOVD $0, R4                  // n = 0
+	MOVD R0, R3
+	MOVD R1, R4
+	MOVD $·decodeShuffle(SB), R14
+	MOVD $0, R19
+	MOVD $·bitWeights(SB), R5
+	VLD1 (R5), [V31.B16]
 
 	// Set up constant vectors
 	VMOVI $13, V20.B16           // \r
 	VMOVI $10, V21.B16           // \n
 	VMOVI $61, V22.B16           // =
 	VMOVI $42, V23.B16           // subtract value
+	VMOVI $46, V24.B16           // .
+	VMOVI $121, V25.B16          // y
+	VMOVI $106, V26.B16          // subtract value of an escaped byte
 
 simd_loop:
-	CMP  $16, R2
+	CMP  $18, R2
 	BLT  byte_tail
 
-	VLD1 (R1), [V0.B16]         // load 16 src bytes
+	VLD1 (R1), [V0.B16]          // load 16 src bytes
 
 	// Compare against special characters
-	VCMEQ V20.B16, V0.B16, V1.B16   // V1 = (byte == \r)
-	VCMEQ V21.B16, V0.B16, V2.B16   // V2 = (byte == \n)
-	VCMEQ V22.B16, V0.B16, V3.B16   // V3 = (byte == =)
-
-	// Combine all specials: \r | \n | =
-	VORR V1.B16, V2.B16, V4.B16
-	VORR V4.B16, V3.B16, V4.B16
+	VCMEQ V20.B16, V0.B16, V3.B16  // V3 = (byte == \r)
+	VCMEQ V21.B16, V0.B16, V4.B16  // V4 = (byte == \n)
+	VCMEQ V22.B16, V0.B16, V5.B16  // V5 = (byte == =), kept for decoding escapes
 
 	// Quick check: any specials at all?
-	VMOV V4.D[0], R6
-	VMOV V4.D[1], R7
-	ORR  R6, R7, R8
+	VORR V3.B16, V4.B16, V6.B16
+	VORR V5.B16, V6.B16, V6.B16
+	VMOV V6.D[0], R8
+	VMOV V6.D[1], R9
+	ORR  R8, R9, R8
 	CBNZ R8, has_specials
 
 	// === Fast path: no specials, subtract 42 and store all 16 ===
@@ -53,20 +75,111 @@ simd_loop:
 	VST1 [V0.B16], (R0)
 	ADD  $16, R0
 	ADD  $16, R1
-	ADD  $16, R4
 	SUB  $16, R2
 	B    simd_loop
 
 has_specials:
-	// Special byte found in this chunk.
-	// Fall through to byte_tail to process bytes one at a time.
-	// We do NOT store a partial 16-byte SIMD result because when
-	// dst and src share the same buffer (in-place decoding), the
-	// extra bytes past the clean prefix would corrupt unread source data.
-	B    byte_tail
+	MOVEMASK(V3, V6, R6)
+	MOVEMASK(V4, V6, R7)
+	MOVEMASK(V5, V6, R11)
+
+	// "==" never comes from an encoder, the second "=" would be escaped
+	AND  R11<<1, R11, R8
+	CBNZ R8, byte_tail
+
+	ADD  $1, R1, R8
+	VLD1 (R8), [V1.B16]          // V1[i] = byte after V0[i]
+	ADD  $2, R1, R8
+	VLD1 (R8), [V2.B16]          // V2[i] = byte after V1[i]
+
+	// An escaped \r or \n is handled as a line ending by decodeGeneric
+	VCMEQ V20.B16, V1.B16, V3.B16
+	VCMEQ V21.B16, V1.B16, V4.B16
+	VORR  V3.B16, V4.B16, V3.B16
+	MOVEMASK(V3, V6, R8)
+	TST  R11, R8
+	BNE  byte_tail
+
+	// Bytes starting a line, after \n
+	MOVD $0, R10                 // R10 = \n followed by a "." to drop
+	CBZ  R7, decode_chunk
+
+	// "\n=y" ends the body
+	VCMEQ V22.B16, V1.B16, V3.B16
+	VCMEQ V25.B16, V2.B16, V4.B16
+	VAND  V3.B16, V4.B16, V3.B16
+	MOVEMASK(V3, V6, R8)
+	TST  R7, R8
+	BNE  byte_tail
+
+	VCMEQ V24.B16, V1.B16, V3.B16
+	MOVEMASK(V3, V6, R10)
+	AND  R7, R10, R10
+	CBZ  R10, decode_chunk
+
+	// "\n.\r", "\n.\n" and "\n.=" may end the article
+	VCMEQ V20.B16, V2.B16, V3.B16
+	VCMEQ V21.B16, V2.B16, V4.B16
+	VORR  V3.B16, V4.B16, V3.B16
+	VCMEQ V22.B16, V2.B16, V4.B16
+	VORR  V3.B16, V4.B16, V3.B16
+	MOVEMASK(V3, V6, R8)
+	TST  R10, R8
+	BNE  byte_tail
+
+decode_chunk:
+	// Escaped lanes take the following byte less 106, others the byte less 42
+	VSUB V23.B16, V0.B16, V0.B16
+	VSUB V26.B16, V1.B16, V3.B16
+	VBIT V5.B16, V3.B16, V0.B16
+
+	// Lanes to drop: \r, \n, escaped bytes and unstuffed dots.
+	// Bit 16 is the byte after the chunk, consumed by an escape or a dot.
+	ORR  R10, R11, R9
+	LSL  $1, R9, R9
+	ORR  R6, R9, R9
+	ORR  R7, R9, R9
+	LSR  $16, R9, R15
+	ADD  $16, R15, R15           // R15 = src bytes consumed
+	AND  $0xFFFF, R9, R9
+
+	// Count the \n lanes, one at a time as there are few
+count_lines:
+	CBZ  R7, counted
+	SUB  $1, R7, R8
+	AND  R8, R7, R7
+	ADD  $1, R19
+	B    count_lines
+
+counted:
+	// Squeeze out the dropped lanes with TBL, 8 lanes at a time from
+	// ·decodeShuffle. The high half is stored right after the kept low
+	// lanes, their count is where the 0x80 indices of unused lanes start.
+	AND  $0xFF, R9, R12
+	LSR  $8, R9, R13
+	MOVD (R14)(R12<<3), R12
+	MOVD (R14)(R13<<3), R13
+	ORR  $0x0808080808080808, R13, R13
+	VMOV R12, V6.D[0]
+	VMOV R13, V6.D[1]
+	VTBL V6.B16, [V0.B16], V6.B16
+	VST1 [V6.B8], (R0)
+	VMOV V6.D[1], R5
+	AND  $0x8080808080808080, R12, R12
+	RBIT R12, R12
+	CLZ  R12, R12
+	ADD  R12>>3, R0, R0
+	MOVD R5, (R0)
+	AND  $0x8080808080808080, R13, R13
+	RBIT R13, R13
+	CLZ  R13, R13
+	ADD  R13>>3, R0, R0
+	ADD  R15, R1
+	SUB  R15, R2
+	B    simd_loop
 
 byte_tail:
-	// Process remaining bytes one at a time, stop at any special
+	// Decode up to the next special byte, for decodeGeneric to handle
 	CBZ  R2, done
 	MOVBU (R1), R6
 	CMP  $13, R6                 // \r?
@@ -79,11 +192,14 @@ byte_tail:
 	SUB  $42, R6, R6
 	MOVB R6, (R0)
 	ADD  $1, R0
-	ADD  $1, R4
 	ADD  $1, R1
 	SUB  $1, R2
 	B    byte_tail
 
 done:
-	MOVD R4, ret+48(FP)
+	SUB  R3, R0, R0
+	SUB  R4, R1, R1
+	MOVD R0, nDst+48(FP)
+	MOVD R1, nSrc+56(FP)
+	MOVD R19, lines+64(FP)
 	RET
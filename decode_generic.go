@@ -3,12 +3,14 @@ package rapidyenc
 // decodeGeneric is the pure Go scalar yEnc incremental decoder.
 // It decodes src into dst, handling CRLF stripping, escape sequences,
 // dot-unstuffing (raw/NNTP mode), and end detection (=y control, .\r\n article end).
+// A bare LF is treated as a line ending like CRLF.
+// lines is the number of \n consumed, for the line numbers in [DecodeError].
 //
 // This is a faithful port of do_decode_end_scalar<true> from decoder_common.h.
-func decodeGeneric(dst, src []byte, state *State) (nDst, nSrc int, end End) {
+func decodeGeneric(dst, src []byte, state *State) (nDst, nSrc, lines int, end End) {
 	sLen := len(src)
 	if sLen == 0 {
-		return 0, 0, EndNone
+		return 0, 0, 0, EndNone
 	}
 
 	p := 0 // dst write offset
@@ -19,7 +21,7 @@ func decodeGeneric(dst, src []byte, state *State) (nDst, nSrc int, end End) {
 	case StateCRLFEQ:
 		if src[i] == 'y' {
 			*state = StateNone
-			return 0, 1, EndControl
+			return 0, 1, 0, EndControl
 		}
 		// Not 'y' — fall through to EQ handling
 		fallthrough
@@ -28,6 +30,9 @@ func decodeGeneric(dst, src []byte, state *State) (nDst, nSrc int, end End) {
 		dst[p] = c - 42 - 64
 		p++
 		i++
+		if c == '\n' {
+			lines++
+		}
 		if c != '\r' {
 			if i >= sLen {
 				*state = StateNone
@@ -45,6 +50,7 @@ func decodeGeneric(dst, src []byte, state *State) (nDst, nSrc int, end End) {
 			goto stateHandled
 		}
 		i++
+		lines++
 		if i >= sLen {
 			*state = StateCRLF
 			goto done
@@ -78,7 +84,7 @@ handleCRLF:
 		}
 		if src[i] == 'y' {
 			*state = StateNone
-			return p, i + 1, EndControl
+			return p, i + 1, lines, EndControl
 		}
 		// escape char
 		c := src[i]
@@ -88,6 +94,9 @@ handleCRLF:
 			// don't advance i — reprocess \r for \r\n handling
 			goto stateHandled
 		}
+		if c == '\n' {
+			lines++
+		}
 		i++
 		if i >= sLen {
 			*state = StateNone
@@ -98,6 +107,11 @@ handleCRLF:
 	goto stateHandled
 
 handleCRLFDT:
+	if src[i] == '\n' {
+		// LF only article end: \n.\n
+		*state = StateCRLF
+		return p, i + 1, lines + 1, EndArticle
+	}
 	if src[i] == '\r' {
 		i++
 		if i >= sLen {
@@ -115,7 +129,7 @@ handleCRLFDT:
 		}
 		if src[i] == 'y' {
 			*state = StateNone
-			return p, i + 1, EndControl
+			return p, i + 1, lines, EndControl
 		}
 		// escape char
 		c := src[i]
@@ -124,6 +138,9 @@ handleCRLFDT:
 		if c == '\r' {
 			goto stateHandled
 		}
+		if c == '\n' {
+			lines++
+		}
 		i++
 		if i >= sLen {
 			*state = StateNone
@@ -137,11 +154,14 @@ handleCRLFDT:
 handleCRLFDTCR:
 	if src[i] == '\n' {
 		*state = StateCRLF
-		return p, i + 1, EndArticle
+		return p, i + 1, lines + 1, EndArticle
 	}
 	// Not \n after \r\n.\r — the \r wasn't part of end sequence
-	// Back up so \r is reprocessed (it might start a new \r\n sequence)
-	i--
+	// Back up so \r is reprocessed (it might start a new \r\n sequence),
+	// unless it ended the previous call, then it is skipped as in StateCR
+	if i > 0 {
+		i--
+	}
 	goto stateHandled
 
 stateHandled:
@@ -156,95 +176,35 @@ mainLoop:
 				i++
 				continue
 			}
-			if src[i+2] == '.' {
-				// skip past \r\n. (dot-unstuffing)
-				i += 3
-				if i >= sLen {
-					*state = StateCRLFDT
-					goto done
-				}
-				// check for article end: .\r\n
-				if src[i] == '\r' {
-					i++
-					if i >= sLen {
-						*state = StateCRLFDTCR
-						goto done
-					}
-					if src[i] == '\n' {
-						*state = StateCRLF
-						return p, i + 1, EndArticle
-					}
-					i-- // not \n, back up to reprocess
-				} else if src[i] == '=' {
-					// dot-stuffed control: \r\n.=y
-					i++
-					if i >= sLen {
-						*state = StateCRLFEQ
-						goto done
-					}
-					if src[i] == 'y' {
-						*state = StateNone
-						return p, i + 1, EndControl
-					}
-					// escape char & continue
-					ec := src[i]
-					dst[p] = ec - 42 - 64
-					p++
-					if ec == '\r' {
-						continue // reprocess \r (i not advanced)
-					}
-					i++
-					continue
-				} else {
-					i-- // back up: after dot, not special, reprocess
-				}
-				i++
-				continue
-			}
-			if src[i+2] == '=' {
-				// \r\n= — possible control
-				i += 3
-				if i >= sLen {
-					*state = StateCRLFEQ
-					goto done
-				}
-				if src[i] == 'y' {
-					*state = StateNone
-					return p, i + 1, EndControl
-				}
-				// escape char & continue
-				ec := src[i]
-				dst[p] = ec - 42 - 64
-				p++
-				if ec == '\r' {
-					continue // reprocess \r
-				}
-				i++
-				continue
-			}
-			// bare \r\n — skip both
-			i++
-			fallthrough
+			i += 2
+			lines++
+			goto handleCRLF
 		case '\n':
+			// A bare LF also ends the line, yEnc escapes it within data
 			i++
-			continue
+			lines++
+			goto handleCRLF
 		case '=':
 			ec := src[i+1]
 			dst[p] = ec - 42 - 64
 			p++
 			if ec != '\r' {
+				if ec == '\n' {
+					lines++
+				}
 				i += 2
 			} else {
 				i++ // advance past '=', reprocess '\r'
 			}
 			continue
 		default:
-			// SIMD fast path: process multiple non-special bytes at once
+			// SIMD fast path: decode up to anything needing the state machine
 			if useSIMDDecode {
-				n := decodeFast(dst[p:], src[i:sLen-2])
-				if n > 0 {
-					p += n
-					i += n
+				nd, ns, nl := decodeFast(dst[p:], src[i:sLen-2])
+				if ns > 0 {
+					p += nd
+					i += ns
+					lines += nl
 					continue
 				}
 			}
@@ -264,6 +224,7 @@ mainLoop:
 			if src[i+1] == '\n' {
 				*state = StateCRLF
 				i += 2
+				lines++
 				goto done
 			}
 			// bare \r — skip
@@ -271,12 +232,16 @@ mainLoop:
 			// fall through to process next byte
 		case '\n':
 			i++
-			// fall through to process final byte
+			lines++
+			goto handleCRLF
 		case '=':
 			ec := src[i+1]
 			dst[p] = ec - 42 - 64
 			p++
 			if ec != '\r' {
+				if ec == '\n' {
+					lines++
+				}
 				i += 2
 			} else {
 				i++ // past '=', reprocess \r as final byte
@@ -303,12 +268,13 @@ checkFinal:
 			case '\r':
 				*state = StateCR
 			default:
-				*state = StateNone
+				*state = StateCRLF
+				lines++
 			}
 		}
 		i++
 	}
 
 done:
-	return p, i, EndNone
+	return p, i, lines, EndNone
 }
@@ -1,4 +1,4 @@
-//go:build !(amd64 || arm64)
+//go:build purego || !(amd64 || arm64)
 
 package rapidyenc
 
@@ -6,4 +6,4 @@ package rapidyenc
 var useSIMDDecode = false
 
 // decodeFast is a no-op stub on platforms without SIMD.
-func decodeFast(dst, src []byte) int { return 0 }
+func decodeFast(dst, src []byte) (nDst, nSrc, lines int) { return 0, 0, 0 }
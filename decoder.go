@@ -2,14 +2,28 @@ package rapidyenc
 
 import (
 	"bytes"
-	"encoding/binary"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"hash"
 	"hash/crc32"
 	"io"
-	"strconv"
+	"slices"
+	"sync"
+)
+
+const (
+	// defaultBufferSize is the initial size of the buffer holding encoded data read from r.
+	defaultBufferSize = 32 * 1024
+
+	// maxBufferedLineLength bounds how much data is buffered while waiting for the end of a header line.
+	maxBufferedLineLength = 64 * 1024
+
+	// minReadSize is the smallest destination decoded into directly, smaller reads are
+	// served from an internal scratch buffer. It fits any single decoded uuencoded line.
+	minReadSize = 64
+
+	// maxConsecutiveEmptyReads is the number of (0, nil) reads tolerated from r.
+	maxConsecutiveEmptyReads = 100
 )
 
 type Decoder struct {
@@ -20,207 +34,701 @@ type Decoder struct {
 	begin bool
 	part  bool
 	end   bool
-	crc   bool
+	done  bool // article terminator ".\r\n" reached
+
+	State      State
+	format     Format
+	actualSize int64
+	hash       hash.Hash32
+
+	// fields is reused between header lines to avoid allocating
+	fields []HeaderField
+
+	strictness Strictness
+	warnings   []Warning
+	sizeKnown  bool  // partSize was given by valid "=ypart" or single-part "=ybegin" headers
+	partSize   int64 // Size given by "=ypart" or single-part "=ybegin", "=yend size=" may differ
+
+	// Recovery mode, see WithRecovery
+	recover  bool
+	report   RecoveryReport
+	line     recoveryLine
+	skipRest bool // The "=yend" trailer is missing, remaining lines are skipped
+
+	// raw decodes a body without headers, see WithRaw
+	raw bool
+
+	// headerErr is the first inconsistent header in Strict mode or the first
+	// invalid uuencoded line, decoding stops once set
+	headerErr error
 
-	State       State
-	format      Format
-	actualSize  int64
-	expectedCrc uint32
-	hash        hash.Hash32
+	// offset and lines count the encoded bytes and lines consumed, for [DecodeError]
+	offset int64
+	lines  int64
 
+	// Position of the "=yend" line, endHeader is its index in header
+	endOffset int64
+	endLine   int64
+	endHeader int
+
+	// header accumulates the header lines, see RawHeaders
+	header []byte
+
+	// err is the error returned by r, once set no further reads are made
 	err error
 
-	// remainder contains bytes that have been read from r but were not sufficient to copy out via Read
-	remainder []byte
+	// buf[rpos:wpos] contains bytes that have been read from r but not yet decoded
+	buf        []byte
+	rpos, wpos int
+
+	// pending contains decoded bytes not yet returned to a Read smaller than minReadSize
+	pending    []byte
+	pendingErr error
+	scratch    [minReadSize]byte
 }
 
-func NewDecoder(r io.Reader) *Decoder {
-	return &Decoder{
+func NewDecoder(r io.Reader, opts ...DecoderOption) *Decoder {
+	d := &Decoder{
 		r:    r,
 		hash: crc32.NewIEEE(),
 	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// WithRaw decodes a body encoded without headers, as written by an [Encoder]
+// with [Meta.Raw] set. Decoding starts at the first byte and ends at a "=y"
+// line, the ".\r\n" article terminator or the end of the input. The size and
+// CRC32 of the decoded data are reported in Meta.PartSize and Meta.Hash.
+func WithRaw() DecoderOption {
+	return func(d *Decoder) {
+		d.raw = true
+		d.startRaw()
+	}
+}
+
+// startRaw positions d at the start of a raw body.
+func (d *Decoder) startRaw() {
+	d.Meta.Raw = true
+	d.format = FormatYenc
+	d.body = true
+}
+
+// Reset discards the [Decoder] d's state and makes it equivalent to the
+// result of [NewDecoder], but reading from r instead. The internal buffers
+// are kept, this permits reusing a [Decoder] rather than allocating a new one.
+// Options given to [NewDecoder] remain in effect.
+func (d *Decoder) Reset(r io.Reader) {
+	d.hash.Reset()
+
+	*d = Decoder{
+		r:          r,
+		hash:       d.hash,
+		buf:        d.buf,
+		fields:     d.fields[:0],
+		header:     d.header[:0],
+		strictness: d.strictness,
+		recover:    d.recover,
+		raw:        d.raw,
+	}
+	if d.raw {
+		d.startRaw()
+	}
+}
+
+var decoderPool = sync.Pool{
+	New: func() any {
+		return NewDecoder(nil)
+	},
+}
+
+// AcquireDecoder returns a [Decoder] reading from r, taken from a package
+// level pool when one is available. Pass it to [ReleaseDecoder] when done
+// so that decoding many articles does not allocate per article.
+func AcquireDecoder(r io.Reader, opts ...DecoderOption) *Decoder {
+	d := decoderPool.Get().(*Decoder)
+	d.Reset(r)
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// ReleaseDecoder returns d to the pool used by [AcquireDecoder].
+// d, including its Meta, must not be used after calling ReleaseDecoder.
+func ReleaseDecoder(d *Decoder) {
+	if d == nil {
+		return
+	}
+
+	// Don't hold on to buffers that grew for unusually long lines
+	if cap(d.buf) > defaultBufferSize {
+		d.buf = nil
+	}
+
+	d.Reset(nil)
+	d.strictness = Lenient
+	d.recover = false
+	d.raw = false
+	decoderPool.Put(d)
 }
 
 var (
 	ErrDataMissing    = errors.New("no binary data")
 	ErrDataCorruption = errors.New("data corruption detected") // io.EOF or ".\r\n" reached before =yend
 	ErrCrcMismatch    = errors.New("crc32 mismatch")
-	ErrUU             = errors.New("data is uuencoded")
+
+	// Deprecated: uuencoded articles are now decoded by [Decoder], ErrUU is no longer returned.
+	ErrUU = errors.New("data is uuencoded")
 )
 
+// Read reads decoded data into p. Any size of p is supported, encoded data
+// is buffered internally so header lines may span any number of reads from
+// the underlying reader.
+//
+// At the end of the article Read returns [io.EOF] if the decoded data
+// matches the yEnc headers, otherwise an error describing the mismatch.
 func (d *Decoder) Read(p []byte) (int, error) {
-	if d.err != nil {
-		return 0, d.metaError()
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if len(d.pending) == 0 && d.pendingErr == nil {
+		if len(p) >= minReadSize {
+			return d.read(p)
+		}
+
+		n, err := d.read(d.scratch[:])
+		d.pending = d.scratch[:n]
+		d.pendingErr = err
 	}
 
-	// Restore previously read data
-	if len(p) < len(d.remainder) {
-		return 0, fmt.Errorf("[rapidyenc] remainder larger than reader: %d < %d", len(p), len(d.remainder))
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+	if len(d.pending) > 0 {
+		return n, nil
 	}
-	nremainder := copy(p, d.remainder)
-	d.remainder = d.remainder[nremainder:]
 
-	// Use p as scratch space
-	var n int
-	n, d.err = d.r.Read(p[nremainder:])
-	p = p[:n+nremainder]
-	dst := p
+	err := d.pendingErr
+	d.pendingErr = nil
+	return n, err
+}
+
+// read decodes into p, which must be at least minReadSize long, reading from r as required.
+func (d *Decoder) read(p []byte) (int, error) {
+	b, err := d.next(p)
+	return len(b), err
+}
+
+// next decodes the next chunk of data, reading from r as required. The data is
+// decoded into p, or when p is nil in place within the internal buffer.
+// It returns the decoded data which is only valid until the next call.
+func (d *Decoder) next(p []byte) ([]byte, error) {
+	for {
+		if d.headerErr != nil {
+			return nil, d.headerErr
+		}
+		if d.done || (d.err != nil && d.rpos == d.wpos) {
+			return nil, d.metaError()
+		}
 
-	var decoded int
-	if d.body && d.format == FormatYenc {
-		nd, ns, err := d.decodeYenc(dst, p)
+		if d.rpos == d.wpos {
+			d.fill()
+			continue
+		}
+
+		src := d.buf[d.rpos:d.wpos]
+		dst := p
+		if dst == nil {
+			dst = src
+		}
+
+		nd, ns, err := d.decode(dst, src)
+		d.rpos += ns
+		if err != nil || nd > 0 {
+			return dst[:nd], err
+		}
+
+		if ns == 0 {
+			// Incomplete line or escape sequence, more data is needed
+			if d.err != nil {
+				return nil, d.metaError()
+			}
+			d.fill()
+		}
+	}
+}
+
+// WriteTo implements [io.WriterTo], so that [io.Copy] hands decoded data
+// straight to w without an intermediate buffer. Data is decoded in place
+// within the [Decoder]'s internal buffer.
+//
+// A nil error is returned once the end of a valid article is reached,
+// otherwise the same errors as [Decoder.Read] are returned.
+func (d *Decoder) WriteTo(w io.Writer) (n int64, err error) {
+	// Flush anything buffered by a previous small Read
+	if len(d.pending) > 0 {
+		nw, err := w.Write(d.pending)
+		n += int64(nw)
+		d.pending = d.pending[nw:]
+		if err != nil {
+			return n, err
+		}
+	}
+	if err := d.pendingErr; err != nil {
+		d.pendingErr = nil
+		if err == io.EOF {
+			return n, nil
+		}
+		return n, err
+	}
+
+	for {
+		b, err := d.next(nil)
+		if len(b) > 0 {
+			nw, werr := w.Write(b)
+			n += int64(nw)
+			if werr != nil {
+				return n, werr
+			}
+			if nw != len(b) {
+				return n, io.ErrShortWrite
+			}
+		}
+		if err == io.EOF {
+			return n, nil
+		}
 		if err != nil {
-			return nd, err
+			return n, err
 		}
-		p = p[ns:]
-		dst = dst[nd:]
-		decoded += nd
+	}
+}
+
+// makeRoom slides unconsumed data to the front of buf, growing it when it is already full.
+func (d *Decoder) makeRoom() {
+	if d.rpos > 0 {
+		d.wpos = copy(d.buf, d.buf[d.rpos:d.wpos])
+		d.rpos = 0
 	}
 
-	// Line by line processing
-	if !d.body {
-		for {
-			line, after, found := bytes.Cut(p, []byte("\r\n"))
-			if !found {
+	if d.wpos == len(d.buf) {
+		d.buf = append(d.buf, make([]byte, max(defaultBufferSize, len(d.buf)))...)
+		d.buf = d.buf[:cap(d.buf)]
+	}
+}
+
+// fill reads a new chunk from r into buf.
+func (d *Decoder) fill() {
+	d.makeRoom()
+
+	for range maxConsecutiveEmptyReads {
+		n, err := d.r.Read(d.buf[d.wpos:])
+		d.wpos += n
+		if err != nil {
+			d.err = err
+			return
+		}
+		if n > 0 {
+			return
+		}
+	}
+
+	d.err = io.ErrNoProgress
+}
+
+// decode consumes encoded bytes from src and writes decoded data to dst.
+// dst may alias src as long as it does not start after it, decoded data never
+// overtakes the encoded data it came from.
+//
+// Header lines are only consumed once they are complete, so nSrc may be less
+// than len(src) when more data is needed. Decoding also stops when dst is full
+// or the end of the article has been reached.
+func (d *Decoder) decode(dst, src []byte) (nDst, nSrc int, err error) {
+	for nSrc < len(src) && !d.done {
+		if d.body && d.format == FormatYenc {
+			n := min(len(dst)-nDst, len(src)-nSrc)
+			if n == 0 {
 				break
 			}
-			p = after
 
-			if bytes.Equal(line, []byte(".")) {
-				d.err = io.EOF
+			lf, nul := -1, -1
+			if d.recover {
+				// Decode a line at a time so that each can be classified
+				var window []byte
+				window, lf, nul = d.lineWindow(src[nSrc : nSrc+n])
+				n = len(window)
+			}
+
+			nd, ns, lines, err := d.decodeYenc(dst[nDst:], src[nSrc:nSrc+n])
+			if d.recover && err == nil {
+				d.trackLine(ns, nd, lf, nul)
+			}
+			d.offset += int64(ns)
+			d.lines += int64(lines)
+			nDst += nd
+			nSrc += ns
+			if err == io.EOF || (d.raw && !d.body) {
+				// A raw body ends at the first "=y" line
+				d.done = true
+				break
+			}
+			if err != nil {
+				return nDst, nSrc, err
+			}
+			if ns == 0 && d.body {
+				// "\r\n=" needs the next byte to tell whether it starts a "=y" line
 				break
 			}
+			continue
+		}
 
-			if d.format == FormatUnknown {
-				d.format = detectFormat(line)
+		line, _, found := bytes.Cut(src[nSrc:], []byte("\n"))
+		if !found {
+			if len(src)-nSrc > maxBufferedLineLength {
+				err := fmt.Errorf("[rapidyenc] line longer than %d bytes: %w", maxBufferedLineLength, ErrDataCorruption)
+				return nDst, nSrc, &DecodeError{Err: err, Offset: d.offset, Line: d.lines + 1}
 			}
+			break
+		}
+
+		raw := src[nSrc : nSrc+len(line)+1]
+		line = bytes.TrimSuffix(line, []byte("\r"))
+
+		if d.format == FormatUnknown {
+			d.format = detectFormat(line)
+		}
+
+		// A uuencoded data line must fit in dst before it can be consumed
+		if d.format == FormatUU && len(dst)-nDst < min(len(line), minReadSize-1) && !isUUBeginLine(line, d.begin) {
+			break
+		}
 
-			if d.format == FormatYenc {
-				d.processYenc(line)
-				if d.body {
-					nd, ns, err := d.decodeYenc(dst, p)
-					p = p[ns:]
-					dst = dst[nd:]
-					decoded += nd
-					if err != nil {
-						return decoded, err
-					}
-					if d.body {
-						break
-					}
-				}
-			} else if d.format == FormatUU {
-				// TODO: does not uudecode, for now just copies encoded data
-				copy(dst, line)
-				copy(dst[len(line):], "\r\n")
-				nd := len(line) + 2
-				dst = dst[nd:]
-				decoded += nd
+		nSrc += len(raw)
+		d.offset += int64(len(raw))
+		d.lines++
+
+		if bytes.Equal(line, []byte(".")) {
+			d.done = true
+			break
+		}
+
+		switch d.format {
+		case FormatYenc:
+			if d.recover && d.skipLine(line) {
+				d.report.SkippedLines++
+				continue
+			}
+			if err := d.processYenc(line, raw); err != nil {
+				d.headerErr = &DecodeError{Err: err, Offset: d.offset - int64(len(raw)), Line: d.lines, Header: string(line)}
+				return nDst, nSrc, d.headerErr
+			}
+		case FormatUU:
+			// NNTP doubles a leading ".", which a 14 byte line starts with
+			if bytes.HasPrefix(line, []byte("..")) {
+				line = line[1:]
+			}
+			nd, err := d.processUU(dst[nDst:], line)
+			nDst += nd
+			if err != nil {
+				// The line has been consumed, later reads must not skip past it
+				d.headerErr = &DecodeError{Err: err, Offset: d.offset - int64(len(raw)), Line: d.lines}
+				return nDst, nSrc, d.headerErr
 			}
 		}
 	}
 
-	// Save remainder; small amount of data that doesn't have \r\n
-	d.remainder = append(d.remainder, p...)
+	return nDst, nSrc, nil
+}
+
+// PeekMeta reads the headers of the article, up to and including "=ypart"
+// (or "=ybegin" for single-part articles), without decoding any of the body.
+// For uuencoded articles it reads up to the "begin" line.
+//
+// Data read beyond the headers is kept, so the body can then be decoded with
+// Read or WriteTo as usual. Once the body has started PeekMeta returns the
+// headers without reading.
+func (d *Decoder) PeekMeta() (Meta, error) {
+	for !d.body && !d.end {
+		if d.headerErr != nil {
+			return d.Meta.Meta, d.headerErr
+		}
+		if d.done || (d.err != nil && d.rpos == d.wpos) {
+			if err := d.metaError(); err != io.EOF {
+				return d.Meta.Meta, err
+			}
+			break
+		}
+
+		if d.rpos < d.wpos {
+			_, ns, err := d.decode(nil, d.buf[d.rpos:d.wpos])
+			d.rpos += ns
+			if err != nil {
+				return d.Meta.Meta, err
+			}
+			if ns > 0 {
+				continue
+			}
+			if d.format == FormatUU {
+				// The next line is uuencoded data
+				break
+			}
+			if d.err != nil {
+				return d.Meta.Meta, d.metaError()
+			}
+		}
 
-	if d.err == io.EOF {
-		return decoded, d.metaError()
+		d.fill()
 	}
 
-	return decoded, nil
+	return d.Meta.Meta, nil
+}
+
+// RawHeaders returns the "=ybegin", "=ypart" and "=yend" lines read so far
+// verbatim, including line endings. All of them have been read once decoding
+// reaches the end of the article.
+func (d *Decoder) RawHeaders() string {
+	return string(d.header)
 }
 
 func (d *Decoder) metaError() error {
-	if len(d.remainder) > 0 {
+	err := d.verify()
+	d.finishRecovery(err)
+	return err
+}
+
+// verify returns the error to report at the end of the article, or [io.EOF] when the article is valid.
+func (d *Decoder) verify() error {
+	if !d.done && d.err != io.EOF {
+		return d.err
+	}
+	if !d.done && d.rpos < d.wpos {
 		return io.ErrUnexpectedEOF
 	}
+	if d.raw {
+		// Raw bodies carry no size or checksum to verify against
+		d.Meta.PartSize = d.actualSize
+		d.Meta.Hash = d.hash.Sum32()
+		return io.EOF
+	}
+	begin, end := "\"=ybegin\" header", "\"=yend\" trailer"
 	if d.format == FormatUU {
-		return ErrUU
+		begin, end = "\"begin\" header", "\"end\" trailer"
 	}
 	if !d.begin {
-		return fmt.Errorf("[rapidyenc] end of article without finding \"=ybegin\" header: %w", ErrDataMissing)
+		err := fmt.Errorf("[rapidyenc] end of article without finding %s: %w", begin, ErrDataMissing)
+		return &DecodeError{Err: err, Offset: d.offset, Line: d.lines}
 	}
 	if !d.end {
-		return fmt.Errorf("[rapidyenc] end of article without finding \"=yend\" trailer: %w", ErrDataCorruption)
+		err := fmt.Errorf("[rapidyenc] end of article without finding %s: %w", end, ErrDataCorruption)
+		return &DecodeError{Err: err, Offset: d.offset, Line: d.lines}
+	}
+	if d.format == FormatUU {
+		// uuencoding carries no size or checksum to verify against
+		d.Meta.PartSize = d.actualSize
+		d.Meta.Hash = d.hash.Sum32()
+		return io.EOF
+	}
+
+	expectedSize := d.Meta.PartSize
+	if !d.part {
+		expectedSize = d.Meta.FileSize
 	}
-	if (!d.part && d.Meta.FileSize != d.actualSize) || (d.part && d.Meta.PartSize != d.actualSize) {
-		return fmt.Errorf("[rapidyenc] expected size %d but got %d: %w", d.Meta.PartSize, d.actualSize, ErrDataCorruption)
+	if expectedSize != d.actualSize {
+		err := fmt.Errorf("[rapidyenc] expected size %d but got %d: %w", expectedSize, d.actualSize, ErrDataCorruption)
+		e := d.endError(err)
+		e.ExpectedSize, e.ActualSize = expectedSize, d.actualSize
+		return e
 	}
-	if d.crc && d.expectedCrc != d.Meta.Hash {
-		return fmt.Errorf("[rapidyenc] expected decoded data to have CRC32 hash %#08x but got %#08x: %w", d.expectedCrc, d.Meta.Hash, ErrCrcMismatch)
+	if d.Meta.HasPartCRC && d.Meta.PartCRC != d.Meta.Hash {
+		err := fmt.Errorf("[rapidyenc] expected decoded data to have part CRC32 (pcrc32) %#08x but got %#08x: %w", d.Meta.PartCRC, d.Meta.Hash, ErrCrcMismatch)
+		e := d.endError(err)
+		e.ExpectedCRC, e.ActualCRC = d.Meta.PartCRC, d.Meta.Hash
+		return e
+	}
+	if d.Meta.HasFileCRC && d.Meta.WholeFile() && d.Meta.FileCRC != d.Meta.Hash {
+		err := fmt.Errorf("[rapidyenc] expected decoded data to have file CRC32 (crc32) %#08x but got %#08x: %w", d.Meta.FileCRC, d.Meta.Hash, ErrCrcMismatch)
+		e := d.endError(err)
+		e.ExpectedCRC, e.ActualCRC = d.Meta.FileCRC, d.Meta.Hash
+		return e
 	}
 	return io.EOF
 }
 
-func (d *Decoder) decodeYenc(dst, src []byte) (int, int, error) {
-	nd, ns, end, err := DecodeIncremental(dst, src, &d.State)
+// endError returns a [DecodeError] positioned at the "=yend" line.
+func (d *Decoder) endError(err error) *DecodeError {
+	return &DecodeError{
+		Err:    err,
+		Offset: d.endOffset,
+		Line:   d.endLine,
+		Header: string(bytes.TrimRight(d.header[d.endHeader:], "\r\n")),
+	}
+}
+
+func (d *Decoder) decodeYenc(dst, src []byte) (int, int, int, error) {
+	nd, ns, lines, end, err := decodeIncremental(dst, src, &d.State)
 	if err != nil {
-		return 0, 0, fmt.Errorf("[rapidyenc] failed to decode incremental data: %w", err)
+		return 0, 0, 0, fmt.Errorf("[rapidyenc] failed to decode incremental data: %w", err)
 	}
 
+	// Hashing the chunk while it is still in cache beats folding CRC32 into the
+	// SIMD kernels, which decoded 1MB at 1300 vs 1700 MB/s with SSE2 and 2150
+	// vs 2450 MB/s with AVX2.
 	if _, err := d.hash.Write(dst[:nd]); err != nil {
-		return 0, 0, fmt.Errorf("[rapidyenc] failed to hash data: %w", err)
+		return 0, 0, 0, fmt.Errorf("[rapidyenc] failed to hash data: %w", err)
 	}
 	d.actualSize += int64(nd)
 
 	if end == EndControl {
 		d.body = false
-		return nd, ns - 2, nil
+		return nd, ns - 2, lines, nil
 	}
 
 	if end == EndArticle {
 		d.body = false
-		return nd, ns - 3, io.EOF
+		return nd, ns, lines, io.EOF
 	}
 
 	if d.State == StateCRLFEQ {
 		// Special case: found "\r\n=" but no more data - might be start of =yend
 		d.State = StateCRLF
-		return nd, ns - 1, nil
+		return nd, ns - 1, lines, nil
 	}
 
-	return nd, ns, nil
+	return nd, ns, lines, nil
 }
 
-func (d *Decoder) processYenc(line []byte) {
-	var err error
-	if bytes.HasPrefix(line, []byte("=ybegin ")) {
+// processYenc handles a single line of a yEnc article outside the body.
+// raw is the line including its line ending.
+func (d *Decoder) processYenc(line, raw []byte) error {
+	h, err := parseHeaderLine(line, d.fields[:0])
+	d.fields = h.Fields
+	if h.Kind == HeaderUnknown {
+		return nil
+	}
+	if err != nil {
+		// Malformed values are treated as missing
+		if err := d.warn(WarnInvalidHeader, err); err != nil {
+			return err
+		}
+	}
+
+	d.header = append(d.header, raw...)
+	for i, f := range h.Fields {
+		if isKnownKey(h.Kind, f.Key) && !slices.ContainsFunc(h.Fields[:i], func(prev HeaderField) bool {
+			return bytes.Equal(prev.Key, f.Key)
+		}) {
+			continue
+		}
+		d.Meta.Extra = append(d.Meta.Extra, HeaderKeyValue{Header: h.Kind, Key: string(f.Key), Value: string(f.Value)})
+	}
+
+	switch h.Kind {
+	case HeaderBegin:
 		d.begin = true
-		d.Meta.FileSize, _ = extractInt(line, []byte(" size="))
-		d.Meta.FileName, _ = extractString(line, []byte(" name="))
-		if d.Meta.PartNumber, err = extractInt(line, []byte(" part=")); err != nil {
+		d.Meta.FileSize, _ = h.Int("size")
+		if name, ok := h.Value("name"); ok {
+			d.Meta.FileName = string(name)
+		}
+		var ok bool
+		if d.Meta.PartNumber, ok = h.Int("part"); !ok {
 			d.body = true
+			d.Meta.SinglePart = true
 			d.Meta.PartSize = d.Meta.FileSize
+			d.partSize = d.Meta.FileSize
+			_, d.sizeKnown = h.Int("size")
+		}
+		d.Meta.LineLength, d.Meta.HasLineLength = h.Int("line")
+		d.Meta.TotalParts, ok = h.Int("total")
+		if ok && d.Meta.PartNumber > d.Meta.TotalParts {
+			err := fmt.Errorf("[rapidyenc] part %d is greater than total %d: %w", d.Meta.PartNumber, d.Meta.TotalParts, ErrInvalidHeader)
+			return d.warn(WarnPartNumber, err)
 		}
-		d.Meta.TotalParts, _ = extractInt(line, []byte(" total="))
-	} else if bytes.HasPrefix(line, []byte("=ypart ")) {
+	case HeaderPart:
 		d.part = true
 		d.body = true
-		var begin int64
-		if begin, err = extractInt(line, []byte(" begin=")); err == nil {
+		begin, hasBegin := h.Int("begin")
+		if hasBegin {
 			d.Meta.Offset = begin - 1
 		}
-		if end, err := extractInt(line, []byte(" end=")); err == nil && begin > 0 {
+		end, hasEnd := h.Int("end")
+		if hasEnd && begin > 0 {
 			d.Meta.PartSize = end - d.Meta.Offset
 		}
-	} else if bytes.HasPrefix(line, []byte("=yend ")) {
+		d.sizeKnown = hasBegin && hasEnd && begin >= 1 && end >= begin
+		d.partSize = d.Meta.PartSize
+		switch {
+		case hasBegin && hasEnd && (begin < 1 || end < begin):
+			err := fmt.Errorf("[rapidyenc] part begin %d is after end %d: %w", begin, end, ErrInvalidHeader)
+			return d.warn(WarnPartRange, err)
+		case hasEnd && d.Meta.End() > d.Meta.FileSize:
+			err := fmt.Errorf("[rapidyenc] part end %d is beyond file size %d: %w", d.Meta.End(), d.Meta.FileSize, ErrInvalidHeader)
+			return d.warn(WarnPartRange, err)
+		}
+	case HeaderEnd:
 		d.end = true
-		if d.part {
-			if crc, err := extractCRC(line, []byte(" pcrc32=")); err == nil {
-				d.expectedCrc = crc
-				d.crc = true
+		d.endOffset = d.offset - int64(len(raw))
+		d.endLine = d.lines
+		d.endHeader = len(d.header) - len(raw)
+		d.Meta.PartCRC, d.Meta.HasPartCRC = h.CRC("pcrc32")
+		d.Meta.FileCRC, d.Meta.HasFileCRC = h.CRC("crc32")
+		d.Meta.Hash = d.hash.Sum32()
+
+		d.Meta.PartSize, _ = h.Int("size")
+		if size, ok := h.Int("size"); ok && d.sizeKnown && size != d.partSize {
+			err := fmt.Errorf("[rapidyenc] \"=yend\" size %d differs from expected %d: %w", size, d.partSize, ErrInvalidHeader)
+			if err := d.warn(WarnSizeMismatch, err); err != nil {
+				return err
 			}
-		} else if crc, err := extractCRC(line, []byte(" crc32=")); err == nil {
-			d.expectedCrc = crc
-			d.crc = true
 		}
-		d.Meta.PartSize, _ = extractInt(line, []byte(" size="))
-		d.Meta.Hash = d.hash.Sum32()
+		if part, ok := h.Int("part"); ok && part != d.Meta.PartNumber {
+			err := fmt.Errorf("[rapidyenc] \"=yend\" part %d differs from \"=ybegin\" part %d: %w", part, d.Meta.PartNumber, ErrInvalidHeader)
+			return d.warn(WarnPartMismatch, err)
+		}
+	}
+
+	return nil
+}
+
+// processUU handles a single line of a uuencoded article, writing decoded data to dst.
+// The "begin" header sets the file name and lines after the "end" trailer are ignored.
+func (d *Decoder) processUU(dst, line []byte) (int, error) {
+	if d.end {
+		return 0, nil
+	}
+
+	if !d.begin {
+		if name, ok := parseUUBegin(line); ok {
+			d.begin = true
+			d.Meta.FileName = name
+			return 0, nil
+		}
+	}
+
+	if isUUEnd(line) {
+		d.end = true
+		if d.begin {
+			d.Meta.FileSize = d.actualSize
+		}
+		return 0, nil
+	}
+
+	nd, err := uudecodeLine(dst, line)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := d.hash.Write(dst[:nd]); err != nil {
+		return 0, fmt.Errorf("[rapidyenc] failed to hash data: %w", err)
 	}
+	d.actualSize += int64(nd)
+
+	return nd, nil
 }
 
 func detectFormat(line []byte) Format {
-	if bytes.HasPrefix(line, []byte("=ybegin ")) {
+	if kind, _ := cutHeaderKind(line); kind == HeaderBegin {
 		return FormatYenc
 	}
 
@@ -266,73 +774,22 @@ var (
 
 // DecodeIncremental stops decoding when a yEnc/NNTP end sequence is found
 func DecodeIncremental(dst, src []byte, state *State) (nDst, nSrc int, end End, err error) {
-	if len(src) == 0 {
-		return 0, 0, EndNone, nil
-	}
-
-	if len(dst) < len(src) {
-		return 0, 0, 0, errDestinationTooSmall
-	}
-
-	nDst, nSrc, end = decodeGeneric(dst, src, state)
-	return nDst, nSrc, end, nil
-}
-
-func extractString(data, substr []byte) (string, error) {
-	start := bytes.Index(data, substr)
-	if start == -1 {
-		return "", fmt.Errorf("substr not found: %s", substr)
-	}
-
-	data = data[start+len(substr):]
-	if end := bytes.IndexAny(data, "\x00\r\n"); end != -1 {
-		return string(data[:end]), nil
-	}
-
-	return string(data), nil
+	nDst, nSrc, _, end, err = decodeIncremental(dst, src, state)
+	return nDst, nSrc, end, err
 }
 
-func extractInt(data, substr []byte) (int64, error) {
-	start := bytes.Index(data, substr)
-	if start == -1 {
-		return 0, fmt.Errorf("substr not found: %s", substr)
-	}
-
-	data = data[start+len(substr):]
-	if end := bytes.IndexAny(data, "\x00\x20\r\n"); end != -1 {
-		return strconv.ParseInt(string(data[:end]), 10, 64)
-	}
-
-	return strconv.ParseInt(string(data), 10, 64)
-}
-
-var (
-	errCrcNotfound = errors.New("crc not found")
-)
-
-// extractCRC converts a hexadecimal representation of a crc32 hash
-func extractCRC(data, substr []byte) (uint32, error) {
-	start := bytes.Index(data, substr)
-	if start == -1 {
-		return 0, errCrcNotfound
-	}
-
-	data = data[start+len(substr):]
-	end := bytes.IndexAny(data, "\x00\x20\r\n")
-	if end != -1 {
-		data = data[:end]
+// decodeIncremental is DecodeIncremental also returning the number of \n
+// consumed, so Decoder counts lines without another pass over src.
+func decodeIncremental(dst, src []byte, state *State) (nDst, nSrc, lines int, end End, err error) {
+	if len(src) == 0 {
+		return 0, 0, 0, EndNone, nil
 	}
 
-	// Take up to the last 8 characters
-	parsed := data[len(data)-min(8, len(data)):]
-
-	// Left pad unexpected length with 0
-	if len(parsed) != 8 {
-		padded := []byte("00000000")
-		copy(padded[8-len(parsed):], parsed)
-		parsed = padded
+	if len(dst) < len(src) {
+		return 0, 0, 0, 0, errDestinationTooSmall
 	}
 
-	_, err := hex.Decode(parsed, parsed)
-	return binary.BigEndian.Uint32(parsed), err
+	checkKernel()
+	nDst, nSrc, lines, end = decodeGeneric(dst, src, state)
+	return nDst, nSrc, lines, end, nil
 }
@@ -5,9 +5,13 @@ import (
 	"bytes"
 	"crypto/rand"
 	"fmt"
+	"hash/crc32"
 	"io"
+	mathrand "math/rand/v2"
 	"os"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/stretchr/testify/require"
 )
@@ -46,10 +50,12 @@ func TestDecode(t *testing.T) {
 
 func TestDecodeUU(t *testing.T) {
 	cases := []struct {
-		name string
-		path string
+		name     string
+		path     string
+		expected string
+		fileName string
 	}{
-		{"logo_full", "testdata/logo_full.uu"},
+		{"logo_full", "testdata/logo_full.uu", "testdata/logo_full.svg", "logo-full.svg"},
 	}
 
 	for _, tc := range cases {
@@ -58,18 +64,105 @@ func TestDecodeUU(t *testing.T) {
 			require.NoError(t, err)
 			defer f.Close()
 
-			raw, err := io.ReadAll(f)
+			expected, err := os.ReadFile(tc.expected)
 			require.NoError(t, err)
 
-			dec := NewDecoder(bytes.NewReader(raw))
+			dec := NewDecoder(f)
 			b := bytes.NewBuffer(nil)
 			_, err = io.Copy(b, dec)
-			require.Error(t, err, ErrUU)
-			require.Equal(t, raw, b.Bytes()) // uudecode is not implemented; just test it is unchanged
+			require.NoError(t, err)
+			require.Equal(t, expected, b.Bytes())
+			require.Equal(t, tc.fileName, dec.Meta.FileName)
+			require.Equal(t, int64(len(expected)), dec.Meta.FileSize)
+			require.Equal(t, crc32.ChecksumIEEE(expected), dec.Meta.Hash)
+		})
+	}
+}
+
+func TestDecodeUUVariants(t *testing.T) {
+	cases := []struct {
+		name    string
+		encoded string
+	}{
+		{"backtick", "begin 644 cat.txt\r\n#8V%T\r\n`\r\nend\r\n"},
+		{"space", "begin 644 cat.txt\r\n#8V%T\r\n \r\nend\r\n"},
+		{"stripped padding", "begin 600 cat.txt\r\n!8P\r\n\"870\r\n`\r\nend\r\n"},
+		{"trailing garbage", "begin 644 cat.txt\r\n#8V%T\r\n`\r\nend\r\nsignature\r\n.\r\n"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dec := NewDecoder(bytes.NewReader([]byte(tc.encoded)))
+			b := bytes.NewBuffer(nil)
+			_, err := io.Copy(b, dec)
+			require.NoError(t, err)
+			require.Equal(t, "cat", b.String())
+			require.Equal(t, "cat.txt", dec.Meta.FileName)
+		})
+	}
+}
+
+// TestDecodeUUDotStuffed decodes a 14 byte line, whose length character "."
+// is doubled in transit.
+func TestDecodeUUDotStuffed(t *testing.T) {
+	encoded := "begin 644 x\r\n..:&5N(&1O=',@86QO;F<`\r\n`\r\nend\r\n.\r\n"
+
+	dec := NewDecoder(strings.NewReader(encoded))
+	b := bytes.NewBuffer(nil)
+	_, err := io.Copy(b, dec)
+	require.NoError(t, err)
+	require.Equal(t, "hen dots along", b.String())
+}
+
+func TestDecodeUUTruncated(t *testing.T) {
+	full, err := os.ReadFile("testdata/logo_full.uu")
+	require.NoError(t, err)
+	lines := bytes.SplitAfter(full, []byte("\n"))
+
+	cases := []struct {
+		name    string
+		encoded []byte
+		err     error
+	}{
+		{"cut off", bytes.Join(lines[:26], nil), ErrDataCorruption},
+		{"begin only", []byte("begin 644 x\r\n"), ErrDataCorruption},
+		{"begin only with terminator", []byte("begin 644 x\r\n.\r\n"), ErrDataCorruption},
+		{"no begin", bytes.Join(lines[1:], nil), ErrDataMissing},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dec := NewDecoder(bytes.NewReader(tc.encoded))
+			_, err := io.Copy(io.Discard, dec)
+			require.ErrorIs(t, err, tc.err)
+
+			var decodeErr *DecodeError
+			require.ErrorAs(t, err, &decodeErr)
 		})
 	}
 }
 
+func TestDecodeUUInvalidSticky(t *testing.T) {
+	encoded := "begin 644 cat.txt\r\n#8V\x7fT\r\n`\r\nend\r\n"
+
+	dec := NewDecoder(strings.NewReader(encoded))
+	_, err := dec.Read(make([]byte, 100))
+	require.ErrorIs(t, err, ErrDataCorruption)
+	var decodeErr *DecodeError
+	require.ErrorAs(t, err, &decodeErr)
+	require.Equal(t, int64(2), decodeErr.Line)
+
+	// The invalid line has been consumed, every later call reports it again
+	_, err2 := dec.Read(make([]byte, 100))
+	require.Equal(t, err, err2)
+	_, err2 = dec.Read(make([]byte, 1))
+	require.Equal(t, err, err2)
+	_, err2 = dec.WriteTo(io.Discard)
+	require.Equal(t, err, err2)
+	_, err2 = dec.PeekMeta()
+	require.Equal(t, err, err2)
+}
+
 // TestSplitReads splits "=y" header lines across reads
 func TestSplitReads(t *testing.T) {
 	cases := []struct {
@@ -128,6 +221,324 @@ func TestSplitReads(t *testing.T) {
 	}
 }
 
+func TestDecodeReadSizes(t *testing.T) {
+	raw := make([]byte, 64*1024)
+	_, err := rand.Read(raw)
+	require.NoError(t, err)
+
+	encoded, err := body(raw)
+	require.NoError(t, err)
+
+	for _, size := range []int{1, 2, 3, 7, 16, 63, 64, 65, 129, 4096} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			_, err := encoded.Seek(0, io.SeekStart)
+			require.NoError(t, err)
+
+			dec := NewDecoder(iotest.HalfReader(encoded))
+			b := bytes.NewBuffer(nil)
+			n, err := io.CopyBuffer(b, struct{ io.Reader }{dec}, make([]byte, size))
+			require.NoError(t, err)
+			require.Equal(t, int64(len(raw)), n)
+			require.Equal(t, raw, b.Bytes())
+			require.Equal(t, crc32.ChecksumIEEE(raw), dec.Meta.Hash)
+		})
+	}
+}
+
+func TestDecodeOneByteReader(t *testing.T) {
+	raw := []byte("foobar")
+
+	w := new(bytes.Buffer)
+	name := strings.Repeat("long name ", 5_000)
+	enc, err := NewEncoder(w, Meta{
+		FileName:   name,
+		FileSize:   int64(len(raw)),
+		PartSize:   int64(len(raw)),
+		PartNumber: 1,
+		TotalParts: 1,
+	})
+	require.NoError(t, err)
+	_, err = enc.Write(raw)
+	require.NoError(t, err)
+	require.NoError(t, enc.Close())
+
+	dec := NewDecoder(iotest.OneByteReader(w))
+	b, err := io.ReadAll(iotest.OneByteReader(dec))
+	require.NoError(t, err)
+	require.Equal(t, raw, b)
+	require.Equal(t, name, dec.Meta.FileName)
+
+	// A read ending in "\r\n.\r" not followed by "\n" decodes as when read at once
+	article := "=ybegin line=128 size=3 name=a\r\nklm\r\n.\rxyz\r\n=yend size=3\r\n"
+	want, wantErr := io.ReadAll(NewDecoder(strings.NewReader(article)))
+	require.ErrorIs(t, wantErr, ErrDataCorruption)
+
+	b, err = io.ReadAll(NewDecoder(iotest.OneByteReader(strings.NewReader(article))))
+	require.ErrorIs(t, err, ErrDataCorruption)
+	require.Equal(t, want, b)
+
+	out := new(bytes.Buffer)
+	dw := NewDecodeWriter(out)
+	for i := range len(article) {
+		if _, err = dw.Write([]byte{article[i]}); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = dw.Close()
+	}
+	require.ErrorIs(t, err, ErrDataCorruption)
+	require.Equal(t, want, out.Bytes())
+}
+
+func TestDecodeLF(t *testing.T) {
+	// Bytes that need escaping or dot-stuffing, some of which land at the start of a line
+	raw := make([]byte, 64*1024)
+	_, err := rand.Read(raw)
+	require.NoError(t, err)
+	for i := 0; i < len(raw); i += 7 {
+		raw[i] = []byte{0x04, 0x13, 0xe0, 0xf6}[i%4] // Encode to '.', '=', '\n' and ' '
+	}
+
+	encoded, err := body(raw)
+	require.NoError(t, err)
+	crlf, err := io.ReadAll(encoded)
+	require.NoError(t, err)
+	crlf = append(crlf, ".\r\n"...)
+
+	lines := bytes.SplitAfter(crlf, []byte("\r\n"))
+	var mixed []byte
+	for i, line := range lines {
+		if i%2 == 0 {
+			line = append(bytes.TrimSuffix(line, []byte("\r\n")), '\n')
+		}
+		mixed = append(mixed, line...)
+	}
+
+	articles := map[string][]byte{
+		"lf":    bytes.ReplaceAll(crlf, []byte("\r\n"), []byte("\n")),
+		"mixed": mixed,
+	}
+
+	oldDecode := useSIMDDecode
+	defer func() { useSIMDDecode = oldDecode }()
+
+	run := func(t *testing.T) {
+		for name, article := range articles {
+			t.Run(name, func(t *testing.T) {
+				dec := NewDecoder(iotest.HalfReader(bytes.NewReader(article)))
+				decoded, err := io.ReadAll(dec)
+				require.NoError(t, err)
+				require.Equal(t, raw, decoded)
+				require.Equal(t, crc32.ChecksumIEEE(raw), dec.Meta.Hash)
+				require.Equal(t, "filename", dec.Meta.FileName)
+
+				out := new(bytes.Buffer)
+				w := NewDecodeWriter(out)
+				for _, b := range article {
+					_, err := w.Write([]byte{b})
+					require.NoError(t, err)
+				}
+				require.NoError(t, w.Close())
+				require.Equal(t, raw, out.Bytes())
+			})
+		}
+
+		for _, tc := range []struct {
+			src   string
+			n     int
+			lines int
+			end   End
+			data  string
+		}{
+			{"ab\n=yend", 5, 1, EndControl, "78"},
+			{"ab\n..cd\n", 8, 2, EndNone, "78\x049:"},
+			{"ab\n.\ncd", 5, 2, EndArticle, "78"},
+			{"ab\r\n.\ncd", 6, 2, EndArticle, "78"},
+			{"ab\n.=ycd", 6, 1, EndControl, "78"},
+		} {
+			dst := make([]byte, len(tc.src))
+			var state State
+			nd, ns, lines, end := decodeGeneric(dst, []byte(tc.src), &state)
+			require.Equal(t, tc.end, end, tc.src)
+			require.Equal(t, tc.n, ns, tc.src)
+			require.Equal(t, tc.lines, lines, tc.src)
+			require.Equal(t, tc.data, string(dst[:nd]), tc.src)
+		}
+	}
+
+	useSIMDDecode = false
+	t.Run("generic", run)
+	useSIMDDecode = oldDecode
+	forEachSIMDKernel(t, run)
+}
+
+func TestDecodeLineTooLong(t *testing.T) {
+	encoded := strings.Repeat("x", 2*maxBufferedLineLength)
+
+	dec := NewDecoder(strings.NewReader(encoded))
+	_, err := io.Copy(io.Discard, dec)
+	require.ErrorIs(t, err, ErrDataCorruption)
+}
+
+func TestDecodeWriteToErrors(t *testing.T) {
+	raw := []byte("foobar")
+
+	r, err := body(raw)
+	require.NoError(t, err)
+	valid, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	cases := []struct {
+		name     string
+		encoded  []byte
+		expected error
+	}{
+		{"missing", []byte("hello\r\n.\r\n"), ErrDataMissing},
+		{"truncated", valid[:bytes.Index(valid, []byte("=yend"))], ErrDataCorruption},
+		{"crc", bytes.Replace(valid, fmt.Appendf(nil, "pcrc32=%08x", crc32.ChecksumIEEE(raw)), []byte("pcrc32=00000000"), 1), ErrCrcMismatch},
+		{"size", bytes.Replace(valid, []byte("=yend size=6"), []byte("=yend size=7"), 1), ErrDataCorruption},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var viaRead, viaWriteTo bytes.Buffer
+
+			_, readErr := io.Copy(&viaRead, struct{ io.Reader }{NewDecoder(bytes.NewReader(tc.encoded))})
+			require.ErrorIs(t, readErr, tc.expected)
+
+			_, writeToErr := NewDecoder(bytes.NewReader(tc.encoded)).WriteTo(&viaWriteTo)
+			require.ErrorIs(t, writeToErr, tc.expected)
+			require.Equal(t, readErr.Error(), writeToErr.Error())
+			require.Equal(t, viaRead.String(), viaWriteTo.String())
+		})
+	}
+}
+
+func TestDecodeWriteToAfterRead(t *testing.T) {
+	raw := make([]byte, 100_000)
+	_, err := rand.Read(raw)
+	require.NoError(t, err)
+
+	r, err := body(raw)
+	require.NoError(t, err)
+
+	dec := NewDecoder(r)
+	head := make([]byte, 10)
+	n, err := io.ReadFull(dec, head)
+	require.NoError(t, err)
+
+	b := bytes.NewBuffer(head[:n])
+	_, err = dec.WriteTo(b)
+	require.NoError(t, err)
+	require.Equal(t, raw, b.Bytes())
+}
+
+func TestDecodeMetaCRCs(t *testing.T) {
+	raw := make([]byte, 5000)
+	_, err := rand.Read(raw)
+	require.NoError(t, err)
+
+	// Last of two parts, which includes the whole file crc32
+	var enc *Encoder
+	encoded := new(bytes.Buffer)
+	for _, m := range []Meta{
+		{FileName: "f", FileSize: 5000, PartNumber: 1, TotalParts: 2, Offset: 0, PartSize: 3000},
+		{FileName: "f", FileSize: 5000, PartNumber: 2, TotalParts: 2, Offset: 3000, PartSize: 2000},
+	} {
+		encoded.Reset()
+		if enc == nil {
+			enc, err = NewEncoder(encoded, m, WithLineLength(64))
+		} else {
+			err = enc.Reset(encoded, m)
+		}
+		require.NoError(t, err)
+		_, err = enc.Write(raw[m.Offset:m.End()])
+		require.NoError(t, err)
+		require.NoError(t, enc.Close())
+	}
+
+	dec := NewDecoder(bytes.NewReader(encoded.Bytes()))
+	_, err = io.Copy(io.Discard, dec)
+	require.NoError(t, err)
+	require.True(t, dec.Meta.HasPartCRC)
+	require.Equal(t, crc32.ChecksumIEEE(raw[3000:]), dec.Meta.PartCRC)
+	require.True(t, dec.Meta.HasFileCRC)
+	require.Equal(t, crc32.ChecksumIEEE(raw), dec.Meta.FileCRC)
+	require.True(t, dec.Meta.HasLineLength)
+	require.Equal(t, int64(64), dec.Meta.LineLength)
+
+	corrupt := bytes.Replace(encoded.Bytes(), fmt.Appendf(nil, "pcrc32=%08x", dec.Meta.PartCRC), []byte("pcrc32=00000000"), 1)
+	_, err = io.Copy(io.Discard, NewDecoder(bytes.NewReader(corrupt)))
+	require.ErrorIs(t, err, ErrCrcMismatch)
+	require.ErrorContains(t, err, "pcrc32")
+
+	// crc32 is verified when the part contains the whole file
+	r, err := body(raw)
+	require.NoError(t, err)
+	single, err := io.ReadAll(r)
+	require.NoError(t, err)
+	corrupt = bytes.Replace(single, fmt.Appendf(nil, " crc32=%08x", crc32.ChecksumIEEE(raw)), []byte(" crc32=00000000"), 1)
+	_, err = io.Copy(io.Discard, NewDecoder(bytes.NewReader(corrupt)))
+	require.ErrorIs(t, err, ErrCrcMismatch)
+	require.ErrorContains(t, err, "(crc32)")
+}
+
+func TestDecoderReset(t *testing.T) {
+	first := []byte("first article")
+	second := []byte("second")
+
+	r1, err := body(first)
+	require.NoError(t, err)
+	r2, err := body(second)
+	require.NoError(t, err)
+
+	dec := NewDecoder(r1)
+	b, err := io.ReadAll(dec)
+	require.NoError(t, err)
+	require.Equal(t, first, b)
+
+	dec.Reset(r2)
+	require.Equal(t, DecodedMeta{}, dec.Meta)
+	b, err = io.ReadAll(dec)
+	require.NoError(t, err)
+	require.Equal(t, second, b)
+	require.Equal(t, crc32.ChecksumIEEE(second), dec.Meta.Hash)
+	require.Equal(t, int64(len(second)), dec.Meta.PartSize)
+}
+
+func TestDecoderPoolAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("sync.Pool drops items under the race detector")
+	}
+
+	raw := make([]byte, 256*1024)
+	_, err := rand.Read(raw)
+	require.NoError(t, err)
+
+	r, err := body(raw)
+	require.NoError(t, err)
+
+	buf := make([]byte, 32*1024)
+	decode := func() {
+		_, err := r.Seek(0, io.SeekStart)
+		require.NoError(t, err)
+
+		dec := AcquireDecoder(r)
+		for err == nil {
+			_, err = dec.Read(buf)
+		}
+		require.ErrorIs(t, err, io.EOF)
+		ReleaseDecoder(dec)
+	}
+
+	// Warm up the pool
+	decode()
+
+	// The file name string is the only allocation per article
+	require.LessOrEqual(t, testing.AllocsPerRun(100, decode), 1.0)
+}
+
 func BenchmarkDecoder(b *testing.B) {
 	raw := make([]byte, 1024*1024)
 	_, err := rand.Read(raw)
@@ -136,14 +547,15 @@ func BenchmarkDecoder(b *testing.B) {
 	r, err := body(raw)
 	require.NoError(b, err)
 
-	b.ResetTimer()
-	for b.Loop() {
-		dec := NewDecoder(r)
-		_, err = io.Copy(io.Discard, dec)
-		require.NoError(b, err)
-		_, err = r.Seek(0, io.SeekStart)
-		require.NoError(b, err)
-	}
+	benchSIMDKernels(b, func(b *testing.B) {
+		for b.Loop() {
+			dec := NewDecoder(r)
+			_, err = io.Copy(io.Discard, dec)
+			require.NoError(b, err)
+			_, err = r.Seek(0, io.SeekStart)
+			require.NoError(b, err)
+		}
+	})
 }
 
 func body(raw []byte) (io.ReadSeeker, error) {
@@ -170,105 +582,216 @@ func body(raw []byte) (io.ReadSeeker, error) {
 	return bytes.NewReader(w.Bytes()), nil
 }
 
-func TestExtractString(t *testing.T) {
-	cases := []struct {
-		raw      string
-		expected string
-	}{
-		{"", ""},
-		{"foo", "foo"},
-		{"name=bar", "name=bar"},
-		{"foo bar", "foo bar"},
-		{"before\x00after", "before"},
+func TestDecodeFast(t *testing.T) {
+	if len(simdKernels()) == 0 {
+		t.Skip("no SIMD decoder")
 	}
 
-	for _, tc := range cases {
-		t.Run(tc.raw, func(t *testing.T) {
-			b := []byte(fmt.Sprintf("=ybegin part=1 line=128 size=128 name=%s\r\n", tc.raw))
-			i, err := extractString(b, []byte(" name="))
-			require.NoError(t, err)
-			require.Equal(t, tc.expected, i)
-		})
+	src := make([]byte, 16)
+	for i := range src {
+		src[i] = byte('A') + 42 // 'k' decodes to 'A'
 	}
+	dst := make([]byte, 32)
+
+	forEachSIMDKernel(t, func(t *testing.T) {
+		nd, ns, lines := decodeFast(dst, src)
+		require.Equal(t, 16, ns, "should process all 16 bytes (no specials)")
+		require.Equal(t, 16, nd)
+		require.Zero(t, lines)
+		for i := 0; i < 16; i++ {
+			require.Equal(t, byte('A'), dst[i], "byte %d should be 'A'", i)
+		}
+	})
 }
 
-func TestExtractCRC(t *testing.T) {
+func TestDecodeFastWithSpecials(t *testing.T) {
+	if len(simdKernels()) == 0 {
+		t.Skip("no SIMD decoder")
+	}
+
 	cases := []struct {
-		raw      string
-		expected uint32
+		name  string
+		src   string
+		nSrc  int
+		lines int
+		dst   string
 	}{
-		{"ffffffffa95d3e50", 0xa95d3e50},
-		{"fffffffa95d3e50", 0xa95d3e50},
-		{"ffffffa95d3e50", 0xa95d3e50},
-		{"fffffa95d3e50", 0xa95d3e50},
-		{"ffffa95d3e50", 0xa95d3e50},
-		{"fffa95d3e50", 0xa95d3e50},
-		{"ffa95d3e50", 0xa95d3e50},
-		{"fa95d3e50", 0xa95d3e50},
-		{"a95d3e50", 0xa95d3e50},
-		{"a95d3e5", 0xa95d3e5},
-		{"a95d3e", 0xa95d3e},
-		{"a95d3", 0xa95d3},
-		{"a95d", 0xa95d},
-		{"a95", 0xa95},
-		{"a9", 0xa9},
-		{"a", 0xa},
-		{"", 0},
-		{"12345678 ", 0x12345678}, // space at end
+		{"crlf", "kkkkk\r\nkkkkkkkkkkkkkkkkkkkkkkkkkkk", 34, 1, strings.Repeat("A", 32)},
+		{"lf", "kkkkk\nkkkkkkkkkkkkkkkkkkkkkkkkkkkk", 34, 1, strings.Repeat("A", 33)},
+		{"escape", "kkkkkkkk=\x81kkkkkkkkkkkkkkkkkkkkkkkk", 34, 0, "AAAAAAAA\x17" + strings.Repeat("A", 24)},
+		{"escape at chunk end", "kkkkkkkkkkkkkkk=\x81kkkkkkkkkkkkkkkkk", 34, 0, strings.Repeat("A", 15) + "\x17" + strings.Repeat("A", 17)},
+		{"escape at wide chunk end", strings.Repeat("k", 31) + "=\x81k", 34, 0, strings.Repeat("A", 31) + "\x17A"},
+		{"dot", "kkkkk\r\n..kkkkkkkkkkkkkkkkkkkkkkkkk", 34, 1, "AAAAA\x04" + strings.Repeat("A", 25)},
+		{"lines", "kk\r\nk\nk\r\nkkkkkkkk\nkkkkkk\r\nkkkkkkkk", 34, 5, strings.Repeat("A", 26)},
+		{"control", "kkkkk\r\n=ykkkkkkkkkkkkkkkkkkkkkkkkk", 5, 0, "AAAAA"},
+		{"article end", "kkkkk\r\n.\r\nkkkkkkkkkkkkkkkkkkkkkkk", 5, 0, "AAAAA"},
+		{"escaped lf", "kkkkk=\nkkkkkkkkkkkkkkkkkkkkkkkkkkk", 5, 0, "AAAAA"},
 	}
 
-	for _, tc := range cases {
-		t.Run(tc.raw, func(t *testing.T) {
-			b := []byte(fmt.Sprintf("pcrc32=%s", tc.raw))
-			i, err := extractCRC(b, []byte("pcrc32="))
-			require.NoError(t, err)
-			require.Equal(t, tc.expected, i)
-		})
-	}
+	forEachSIMDKernel(t, func(t *testing.T) {
+		for _, tc := range cases {
+			t.Run(tc.name, func(t *testing.T) {
+				dst := make([]byte, len(tc.src))
+				nd, ns, lines := decodeFast(dst, []byte(tc.src))
+				require.Equal(t, tc.nSrc, ns)
+				require.Equal(t, tc.lines, lines)
+				require.Equal(t, tc.dst, string(dst[:nd]))
+			})
+		}
+	})
 }
 
-func TestDecodeFast(t *testing.T) {
-	src := make([]byte, 16)
-	for i := range src {
-		src[i] = byte('A') + 42 // 'k' decodes to 'A'
+// TestDecodeFastParity compares decodeGeneric with and without the SIMD
+// decoder on input dense in special characters, decoded in random chunks.
+func TestDecodeFastParity(t *testing.T) {
+	if len(simdKernels()) == 0 {
+		t.Skip("no SIMD decoder")
 	}
-	dst := make([]byte, 32)
 
-	n := decodeFast(dst, src)
-
-	require.Equal(t, 16, n, "should process all 16 bytes (no specials)")
-	for i := 0; i < 16; i++ {
-		require.Equal(t, byte('A'), dst[i], "byte %d should be 'A'", i)
+	alphabet := []byte("\r\n=.yabcdefghijklmnopqrstuvwxyz\x00\xff")
+	seed := make([]byte, 32)
+	_, err := rand.Read(seed)
+	require.NoError(t, err)
+	rng := mathrand.New(mathrand.NewChaCha8([32]byte(seed)))
+
+	decode := func(simd bool, src []byte, chunks []int) ([]byte, []int) {
+		useSIMDDecode = simd
+		dst := make([]byte, len(src))
+		var trace []int
+		var state State
+		p, i := 0, 0
+		for _, n := range chunks {
+			nd, ns, lines, end := decodeGeneric(dst[p:], src[i:i+n], &state)
+			p += nd
+			i += ns
+			trace = append(trace, nd, ns, lines, int(end), int(state))
+			if end != EndNone {
+				break
+			}
+		}
+		return dst[:p], trace
 	}
+
+	forEachSIMDKernel(t, func(t *testing.T) {
+		for range 2000 {
+			// Mostly plain bytes, with special bytes at a varying density
+			src := make([]byte, rng.IntN(600))
+			density := rng.IntN(40) + 1
+			for i := range src {
+				if rng.IntN(density) == 0 {
+					src[i] = alphabet[rng.IntN(len(alphabet))]
+				} else {
+					src[i] = byte(rng.IntN(256))
+				}
+			}
+			var chunks []int
+			for n := 0; n < len(src); {
+				c := min(rng.IntN(100)+1, len(src)-n)
+				chunks = append(chunks, c)
+				n += c
+			}
+
+			generic, genericTrace := decode(false, src, chunks)
+			simd, simdTrace := decode(true, src, chunks)
+			require.Equal(t, genericTrace, simdTrace, "src %q", src)
+			require.Equal(t, generic, simd, "src %q", src)
+		}
+	})
 }
 
-func TestDecodeFastWithCRLF(t *testing.T) {
-	src := make([]byte, 16)
-	for i := range src {
-		src[i] = byte('A') + 42 // 'k'
-	}
-	src[5] = '\r'
-	src[6] = '\n'
-	dst := make([]byte, 32)
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int
+}
 
-	n := decodeFast(dst, src)
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
 
-	// Stops at first special (\r at position 5)
-	require.Equal(t, 5, n, "should stop at \\r position")
-	for i := 0; i < 5; i++ {
-		require.Equal(t, byte('A'), dst[i], "byte %d should be 'A'", i)
+func TestDecoderPeekMeta(t *testing.T) {
+	raw := make([]byte, 10_000)
+	_, err := rand.Read(raw)
+	require.NoError(t, err)
+
+	for _, meta := range []Meta{
+		{FileName: "multi part.bin", FileSize: 100_000, Offset: 5000, PartSize: int64(len(raw)), PartNumber: 2, TotalParts: 10},
+		{FileName: "single part.bin", FileSize: int64(len(raw)), PartSize: int64(len(raw)), SinglePart: true},
+	} {
+		t.Run(meta.FileName, func(t *testing.T) {
+			w := new(bytes.Buffer)
+			enc, err := NewEncoder(w, meta)
+			require.NoError(t, err)
+			_, err = enc.Write(raw)
+			require.NoError(t, err)
+			require.NoError(t, enc.Close())
+			encoded := w.Bytes()
+			headerSize := bytes.Index(encoded, []byte("\r\n")) + 2
+			if !meta.SinglePart {
+				headerSize += bytes.Index(encoded[headerSize:], []byte("\r\n")) + 2
+			}
+
+			for _, oneByte := range []bool{false, true} {
+				r := &countingReader{r: bytes.NewReader(encoded)}
+				var src io.Reader = r
+				if oneByte {
+					src = iotest.OneByteReader(r)
+				}
+
+				dec := NewDecoder(src)
+				peeked, err := dec.PeekMeta()
+				require.NoError(t, err)
+				require.Equal(t, meta, peeked)
+				if oneByte {
+					require.Equal(t, headerSize, r.n, "only the headers are read")
+				}
+
+				// Peeking again doesn't read
+				n := r.n
+				peeked, err = dec.PeekMeta()
+				require.NoError(t, err)
+				require.Equal(t, meta, peeked)
+				require.Equal(t, n, r.n)
+
+				decoded, err := io.ReadAll(dec)
+				require.NoError(t, err)
+				require.Equal(t, raw, decoded)
+				require.Equal(t, crc32.ChecksumIEEE(raw), dec.Meta.Hash)
+			}
+		})
 	}
 }
 
-func TestDecodeFastWithEquals(t *testing.T) {
-	src := make([]byte, 16)
-	for i := range src {
-		src[i] = byte('A') + 42
-	}
-	src[8] = '='
-	dst := make([]byte, 32)
+func TestDecoderPeekMetaUU(t *testing.T) {
+	f, err := os.Open("testdata/logo_full.uu")
+	require.NoError(t, err)
+	defer f.Close()
+
+	expected, err := os.ReadFile("testdata/logo_full.svg")
+	require.NoError(t, err)
+
+	dec := NewDecoder(iotest.OneByteReader(f))
+	meta, err := dec.PeekMeta()
+	require.NoError(t, err)
+	require.Equal(t, "logo-full.svg", meta.FileName)
+
+	decoded, err := io.ReadAll(dec)
+	require.NoError(t, err)
+	require.Equal(t, expected, decoded)
+}
+
+func TestDecoderPeekMetaErrors(t *testing.T) {
+	_, err := NewDecoder(strings.NewReader("foo\r\nbar\r\n.\r\n")).PeekMeta()
+	require.ErrorIs(t, err, ErrDataMissing)
+
+	_, err = NewDecoder(strings.NewReader("=ybegin part=1 total=1 line=128 size=3 name=a\r\n")).PeekMeta()
+	require.ErrorIs(t, err, ErrDataCorruption)
 
-	n := decodeFast(dst, src)
+	_, err = NewDecoder(strings.NewReader("=ybegin part=1 total=1 line=128 size=3 na")).PeekMeta()
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
 
-	require.Equal(t, 8, n, "should stop at '=' position")
+	_, err = NewDecoder(strings.NewReader("=ybegin part=2 total=1 line=128 size=3 name=a\r\n"), WithStrictness(Strict)).PeekMeta()
+	require.ErrorIs(t, err, ErrInvalidHeader)
 }
@@ -0,0 +1,140 @@
+package rapidyenc
+
+import (
+	"hash/crc32"
+	"io"
+)
+
+// DecodeWriter is the push-mode counterpart of [Decoder], for when encoded
+// data arrives through callbacks rather than an [io.Reader].
+// Encoded bytes written to it are decoded and written to the destination.
+//
+// It is the caller's responsibility to call Close on the [DecodeWriter] when
+// the article is complete, Close verifies the decoded data against the headers.
+type DecodeWriter struct {
+	dst    io.Writer
+	d      Decoder
+	err    error
+	closed bool
+}
+
+// NewDecodeWriter returns a new [DecodeWriter] writing decoded data to dst.
+func NewDecodeWriter(dst io.Writer, opts ...DecoderOption) *DecodeWriter {
+	w := &DecodeWriter{
+		dst: dst,
+		d: Decoder{
+			hash: crc32.NewIEEE(),
+		},
+	}
+	for _, opt := range opts {
+		opt(&w.d)
+	}
+	return w
+}
+
+// Reset discards the [DecodeWriter] w's state and makes it equivalent to the
+// result of [NewDecodeWriter], but writing to dst instead.
+func (w *DecodeWriter) Reset(dst io.Writer) {
+	w.d.Reset(nil)
+	w.dst = dst
+	w.err = nil
+	w.closed = false
+}
+
+// Meta returns the yEnc headers parsed so far, it is complete once Close returns.
+func (w *DecodeWriter) Meta() DecodedMeta {
+	return w.d.Meta
+}
+
+// Warnings returns the problems found in the yEnc headers written so far.
+func (w *DecodeWriter) Warnings() []Warning {
+	return w.d.Warnings()
+}
+
+// Report returns the [RecoveryReport] when [WithRecovery] is used, it is complete once Close returns.
+func (w *DecodeWriter) Report() RecoveryReport {
+	return w.d.Report()
+}
+
+// Write decodes p and writes the decoded data to the destination. Incomplete
+// header lines are buffered until the rest of the line is written. Data after
+// the end of the article (".\r\n") is discarded. Write after Close returns
+// [ErrDecodeWriterClosed].
+func (w *DecodeWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrDecodeWriterClosed
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	n := 0
+	for n < len(p) && !w.d.done {
+		w.d.makeRoom()
+		c := copy(w.d.buf[w.d.wpos:], p[n:])
+		w.d.wpos += c
+		n += c
+
+		if w.err = w.flush(); w.err != nil {
+			return n, w.err
+		}
+	}
+
+	return len(p), nil
+}
+
+// flush decodes as much of the buffered data as possible.
+func (w *DecodeWriter) flush() error {
+	d := &w.d
+	for !d.done && d.rpos < d.wpos {
+		src := d.buf[d.rpos:d.wpos]
+		nd, ns, err := d.decode(src, src)
+		d.rpos += ns
+
+		if nd > 0 {
+			nw, werr := w.dst.Write(src[:nd])
+			if werr != nil {
+				return werr
+			}
+			if nw != nd {
+				return io.ErrShortWrite
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if ns == 0 {
+			// Incomplete line or escape sequence, wait for the next Write
+			break
+		}
+	}
+
+	if d.done {
+		d.rpos, d.wpos = 0, 0
+	}
+
+	return nil
+}
+
+// Close marks the end of the encoded data and returns the result of verifying
+// the decoded data against the yEnc headers: nil when it matches, otherwise
+// the same errors [Decoder] reports such as [ErrCrcMismatch],
+// [ErrDataCorruption] and [ErrDataMissing]. Calling Close again returns
+// [ErrDecodeWriterClosed].
+func (w *DecodeWriter) Close() error {
+	if w.closed {
+		return ErrDecodeWriterClosed
+	}
+	w.closed = true
+
+	if w.err != nil {
+		return w.err
+	}
+
+	w.d.err = io.EOF
+	if err := w.d.metaError(); err != io.EOF {
+		return err
+	}
+
+	return nil
+}
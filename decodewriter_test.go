@@ -0,0 +1,146 @@
+package rapidyenc
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeWriter(t *testing.T) {
+	raw := make([]byte, 200_000)
+	_, err := rand.Read(raw)
+	require.NoError(t, err)
+
+	r, err := body(raw)
+	require.NoError(t, err)
+	encoded, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	for _, size := range []int{1, 2, 3, 100, 4096, len(encoded)} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			decoded := new(bytes.Buffer)
+			w := NewDecodeWriter(decoded)
+
+			for p := encoded; len(p) > 0; {
+				chunk := p[:min(size, len(p))]
+				n, err := w.Write(chunk)
+				require.NoError(t, err)
+				require.Equal(t, len(chunk), n)
+				p = p[len(chunk):]
+			}
+
+			require.NoError(t, w.Close())
+			require.Equal(t, raw, decoded.Bytes())
+			require.Equal(t, crc32.ChecksumIEEE(raw), w.Meta().Hash)
+			require.Equal(t, "filename", w.Meta().FileName)
+			require.Equal(t, int64(len(raw)), w.Meta().PartSize)
+		})
+	}
+}
+
+// TestDecodeWriterSplitControl splits writes right before the "=yend" line
+func TestDecodeWriterSplitControl(t *testing.T) {
+	raw := []byte("foobar")
+
+	r, err := body(raw)
+	require.NoError(t, err)
+	encoded, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	split := bytes.Index(encoded, []byte("=yend"))
+	for _, at := range []int{split - 2, split - 1, split, split + 1} {
+		decoded := new(bytes.Buffer)
+		w := NewDecodeWriter(decoded)
+		_, err = w.Write(encoded[:at])
+		require.NoError(t, err)
+		_, err = w.Write(encoded[at:])
+		require.NoError(t, err)
+		require.NoError(t, w.Close(), "split at %d", at)
+		require.Equal(t, raw, decoded.Bytes())
+
+		// The same split with the final read also returning io.EOF
+		dec := NewDecoder(iotest.DataErrReader(io.MultiReader(bytes.NewReader(encoded[:at]), bytes.NewReader(encoded[at:]))))
+		b, err := io.ReadAll(dec)
+		require.NoError(t, err, "split at %d", at)
+		require.Equal(t, raw, b)
+	}
+}
+
+func TestDecodeWriterErrors(t *testing.T) {
+	raw := []byte("foobar")
+
+	r, err := body(raw)
+	require.NoError(t, err)
+	valid, err := io.ReadAll(r)
+	require.NoError(t, err)
+
+	cases := []struct {
+		name     string
+		encoded  []byte
+		expected error
+	}{
+		{"missing", []byte("hello\r\n.\r\n"), ErrDataMissing},
+		{"truncated", valid[:bytes.Index(valid, []byte("=yend"))], ErrDataCorruption},
+		{"crc", bytes.Replace(valid, fmt.Appendf(nil, "pcrc32=%08x", crc32.ChecksumIEEE(raw)), []byte("pcrc32=00000000"), 1), ErrCrcMismatch},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := NewDecodeWriter(io.Discard)
+			_, err := w.Write(tc.encoded)
+			require.NoError(t, err)
+			require.ErrorIs(t, w.Close(), tc.expected)
+		})
+	}
+}
+
+func TestDecodeWriterUU(t *testing.T) {
+	encoded, err := os.ReadFile("testdata/logo_full.uu")
+	require.NoError(t, err)
+	expected, err := os.ReadFile("testdata/logo_full.svg")
+	require.NoError(t, err)
+
+	decoded := new(bytes.Buffer)
+	w := NewDecodeWriter(decoded)
+	_, err = w.Write(encoded)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.Equal(t, expected, decoded.Bytes())
+	require.Equal(t, "logo-full.svg", w.Meta().FileName)
+}
+
+func TestDecodeWriterArticleEnd(t *testing.T) {
+	raw := []byte("foobar")
+
+	r, err := body(raw)
+	require.NoError(t, err)
+	encoded, err := io.ReadAll(r)
+	require.NoError(t, err)
+	encoded = append(encoded, ".\r\nnext article\r\n"...)
+
+	decoded := new(bytes.Buffer)
+	w := NewDecodeWriter(decoded)
+	n, err := w.Write(encoded)
+	require.NoError(t, err)
+	require.Equal(t, len(encoded), n)
+	require.NoError(t, w.Close())
+	require.Equal(t, raw, decoded.Bytes())
+
+	_, err = w.Write(encoded)
+	require.ErrorIs(t, err, ErrDecodeWriterClosed)
+	require.ErrorIs(t, w.Close(), ErrDecodeWriterClosed)
+
+	w.Reset(decoded)
+	decoded.Reset()
+	_, err = w.Write(encoded)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.Equal(t, raw, decoded.Bytes())
+}
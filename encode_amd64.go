@@ -1,13 +1,26 @@
-//go:build amd64
+//go:build amd64 && !purego
 
 package rapidyenc
 
 var useSIMDEncode = true
 
-// encodeFast adds 42 to each byte in src and stores to dst,
-// stopping at the first byte whose encoded form needs escaping
-// (NUL, CR, LF, or '='). Returns the number of bytes processed.
-// Uses SSE2 SIMD for 16-byte chunks when no escaping is needed.
-//
+// minAVX2LineSize is the shortest line length encodeAVX2 supports, a 32-byte
+// chunk must not span more than one line end.
+const minAVX2LineSize = 2 * minSIMDLineSize
+
+// encodeFast encodes src into dst like encodeGeneric using SIMD, with escapes
+// and line endings handled in the vector. It stops when fewer than 16 bytes
+// are left in src and returns the bytes written, consumed and the new column.
+func encodeFast(dst, src []byte, lineSize, col int) (nDst, nSrc, newCol int) {
+	if useAVX2 && lineSize >= minAVX2LineSize {
+		nDst, nSrc, col = encodeAVX2(dst, src, lineSize, col)
+	}
+	d, s, col := encodeSSE2(dst[nDst:], src[nSrc:], lineSize, col)
+	return nDst + d, nSrc + s, col
+}
+
+//go:noescape
+func encodeSSE2(dst, src []byte, lineSize, col int) (nDst, nSrc, newCol int)
+
 //go:noescape
-func encodeFast(dst, src []byte) int
+func encodeAVX2(dst, src []byte, lineSize, col int) (nDst, nSrc, newCol int)
@@ -1,94 +1,532 @@
+//go:build !purego
+
 #include "textflag.h"
 
-// func encodeFast(dst, src []byte) int
+// EXPAND inserts an "=" before each escaped lane of X4 and adds 64 to the
+// lane, for the escaped lanes given by the 8-bit mask in CX. The result is in
+// X5 and R11 is the number of escapes. Lanes are inserted one at a time,
+// lowest first, by shifting the lanes above up with masks from ·laneMask:
+// lanes up to the escape are kept and the escaped lane is repeated above
+// them, then X5 marks where "=" goes. SSE2 has no byte shuffle.
+// Clobbers CX, R13, X2, X3, X4 and X6.
+#define EXPAND(loop, end) \
+	PXOR   X5, X5;               \
+	XORL   R11, R11;             \
+loop:                                \
+	TESTL  CX, CX;               \
+	JZ     end;                  \
+	BSFL   CX, R13;              \
+	BTRL   R13, CX;              \
+	ADDL   R11, R13;             \
+	INCL   R11;                  \
+	SHLL   $4, R13;              \
+	MOVOU  16(R14)(R13*1), X6;   \
+	MOVOU  (R14)(R13*1), X2;     \
+	PXOR   X6, X2;               \
+	POR    X2, X5;               \
+	MOVOU  X4, X3;               \
+	PSLLDQ $1, X3;               \
+	PAND   X6, X4;               \
+	PANDN  X3, X6;               \
+	POR    X6, X4;               \
+	JMP    loop;                 \
+end:                                 \
+	MOVOU  X5, X3;               \
+	PSLLDQ $1, X3;               \
+	PAND   X7, X3;               \
+	PADDB  X3, X4;               \
+	MOVOU  X5, X3;               \
+	PAND   X12, X3;              \
+	PANDN  X4, X5;               \
+	POR    X3, X5
+
+// func encodeSSE2(dst, src []byte, lineSize, col int) (nDst, nSrc, newCol int)
 //
-// Adds 42 to each byte in src and stores to dst, stopping at the first byte
-// whose encoded form needs escaping (NUL=0, CR=13, LF=10, '='=61).
-// Returns the number of bytes processed.
-TEXT ·encodeFast(SB), NOSPLIT, $0-56
-	MOVQ dst_base+0(FP), DI      // dst pointer
-	MOVQ src_base+24(FP), SI     // src pointer
-	MOVQ src_len+32(FP), CX      // src length
-	XORQ AX, AX                  // bytes processed = 0
-
-	// Set up constant XMM registers
-	// XMM4 = all 42 (add value)
-	MOVQ $0x2A2A2A2A2A2A2A2A, DX
-	MOVQ DX, X4
-	PUNPCKLQDQ X4, X4
-	// XMM5 = all 0 (NUL check)
-	PXOR X5, X5
-	// XMM6 = all 13 (CR)
-	MOVQ $0x0D0D0D0D0D0D0D0D, DX
-	MOVQ DX, X6
-	PUNPCKLQDQ X6, X6
-	// XMM7 = all 10 (LF)
-	MOVQ $0x0A0A0A0A0A0A0A0A, DX
+// SSE2 yEnc encoder. Each 16-byte chunk is encoded without leaving the
+// vector path:
+//
+//   - bytes encoding to NUL, \r, \n or "=" are escaped
+//   - when a line ends within the chunk, the lane in its last column also
+//     escapes space and tab, the lane starting the next line also escapes
+//     space, tab and "."; the line end is found from the columns taken by
+//     the escapes before it and CRLF is inserted there
+//   - a chunk ending exactly at the end of a line leaves newCol at lineSize,
+//     the CRLF is written before the next byte like encodeGeneric does
+//
+// Output is byte-identical to encodeGeneric. lineSize must be at least 32,
+// so a chunk holds at most one line end. Encoding stops when fewer than 16
+// bytes are left in src, or 80 in dst: each chunk writes up to 68 bytes,
+// the bytes past its output are overwritten by the next chunk.
+//
+// Register allocation:
+//   DI = dst write pointer, SI = src read pointer
+//   BX = last src chunk start, R8 = last dst chunk start
+//   R9 = lineSize, R10 = column, R14 = ·laneMask
+//   DX = escaped lanes of the chunk
+//   R12 = lane starting the next line, 32 when the line doesn't end
+//   AX, CX, R11, R13 = temporaries
+//   X0 = encoded chunk, X1-X6 = temporaries, X7 = splat(64)
+//   X8 = splat(42), X9 = zero, X10 = splat(\r), X11 = splat(\n)
+//   X12 = splat(=), X13 = splat(\t), X14 = splat(space), X15 = splat(.)
+TEXT ·encodeSSE2(SB), NOSPLIT, $0-88
+	MOVQ dst_base+0(FP), DI
+	MOVQ dst_len+8(FP), R8
+	LEAQ -80(DI)(R8*1), R8
+	MOVQ src_base+24(FP), SI
+	MOVQ src_len+32(FP), BX
+	LEAQ -16(SI)(BX*1), BX
+	MOVQ lineSize+48(FP), R9
+	MOVQ col+56(FP), R10
+	LEAQ ·laneMask(SB), R14
+
+	// Splat constant vectors
+	MOVQ $0x4040404040404040, DX
 	MOVQ DX, X7
 	PUNPCKLQDQ X7, X7
-	// XMM8 = all 61 (=)
-	MOVQ $0x3D3D3D3D3D3D3D3D, DX
+	MOVQ $0x2A2A2A2A2A2A2A2A, DX
 	MOVQ DX, X8
 	PUNPCKLQDQ X8, X8
+	PXOR X9, X9
+	MOVQ $0x0D0D0D0D0D0D0D0D, DX
+	MOVQ DX, X10
+	PUNPCKLQDQ X10, X10
+	MOVQ $0x0A0A0A0A0A0A0A0A, DX
+	MOVQ DX, X11
+	PUNPCKLQDQ X11, X11
+	MOVQ $0x3D3D3D3D3D3D3D3D, DX
+	MOVQ DX, X12
+	PUNPCKLQDQ X12, X12
+	MOVQ $0x0909090909090909, DX
+	MOVQ DX, X13
+	PUNPCKLQDQ X13, X13
+	MOVQ $0x2020202020202020, DX
+	MOVQ DX, X14
+	PUNPCKLQDQ X14, X14
+	MOVQ $0x2E2E2E2E2E2E2E2E, DX
+	MOVQ DX, X15
+	PUNPCKLQDQ X15, X15
 
 simd_loop:
-	CMPQ CX, $16
-	JLT  byte_tail
+	CMPQ SI, BX
+	JHI  done
+	CMPQ DI, R8
+	JHI  done
 
-	// Load 16 src bytes
+	// Load 16 src bytes and add 42 (encoded = src + 42)
 	MOVOU (SI), X0
-	// Add 42 (encoded = src + 42)
+	PADDB X8, X0
+
+	// Escape NUL, CR, LF and =
+	MOVOU X0, X1
+	PCMPEQB X9, X1
+	MOVOU X0, X2
+	PCMPEQB X10, X2
+	POR  X2, X1
+	MOVOU X0, X2
+	PCMPEQB X11, X2
+	POR  X2, X1
+	MOVOU X0, X2
+	PCMPEQB X12, X2
+	POR  X2, X1
+	PMOVMSKB X1, DX
+
+	// A full line is ended before the chunk
+	CMPQ R10, R9
+	JLT  started
+	MOVW $0x0a0d, (DI)
+	ADDQ $2, DI
+	XORQ R10, R10
+
+started:
+	TESTQ R10, R10
+	JNZ  columns
+
+	// The first character of a line also escapes tab, space and .
 	MOVOU X0, X1
-	PADDB X4, X1
-
-	// Check for specials in encoded output
-	MOVOU X1, X2
-	PCMPEQB X5, X2              // X2 = (encoded == NUL)
-	MOVOU X1, X3
-	PCMPEQB X6, X3              // X3 = (encoded == CR)
-	POR  X3, X2
-	MOVOU X1, X3
-	PCMPEQB X7, X3              // X3 = (encoded == LF)
-	POR  X3, X2
-	MOVOU X1, X3
-	PCMPEQB X8, X3              // X3 = (encoded == =)
-	POR  X3, X2
-
-	// Check if any byte is non-zero
-	PMOVMSKB X2, DX
-	TESTL DX, DX
-	JNZ  byte_tail
-
-	// Fast path: no specials, store encoded bytes
-	MOVOU X1, (DI)
-	ADDQ $16, DI
+	PCMPEQB X13, X1
+	MOVOU X0, X2
+	PCMPEQB X14, X2
+	POR  X2, X1
+	MOVOU X0, X2
+	PCMPEQB X15, X2
+	POR  X2, X1
+	PMOVMSKB X1, AX
+	ANDL $1, AX
+	ORL  AX, DX
+
+columns:
+	MOVQ R9, CX
+	SUBQ R10, CX
+	DECQ CX                      // CX = columns left before the last one
+	MOVQ $32, R12
+	CMPQ CX, $32
+	JGE  expand                  // even if every byte is escaped
+
+	// Count the escapes that start before the last column
+	XORQ AX, AX                  // AX = escapes in the line
+	XORQ R11, R11                // R11 = lane after the last of them
+	MOVL DX, R12
+walk:
+	TESTL R12, R12
+	JZ   walked
+	BSFL R12, R13
+	ADDQ AX, R13                 // column of the escape, relative to the chunk
+	CMPQ R13, CX
+	JGE  walked
+	SUBQ AX, R13
+	INCQ R13
+	MOVQ R13, R11
+	INCQ AX
+	LEAL -1(R12), R13
+	ANDL R13, R12
+	JMP  walk
+
+walked:
+	MOVQ CX, R12
+	SUBQ AX, R12                 // R12 = lane in the last column, unless an escape ends the line
+	CMPQ R11, R12
+	JGT  escape_ends_line
+	CMPQ R12, $16
+	JGE  no_line_end
+
+	// The last character of a line also escapes tab and space
+	MOVOU X0, X1
+	PCMPEQB X13, X1
+	MOVOU X0, X2
+	PCMPEQB X14, X2
+	POR  X2, X1
+	PMOVMSKB X1, R13
+	BTL  R12, R13
+	JCC  last_done
+	BTSL R12, DX
+
+last_done:
+	LEAQ 1(R12)(AX*1), R10
+	BTL  R12, DX
+	ADCQ $0, R10                 // R10 = output offset of the line end
+	INCQ R12
+	JMP  next_line
+
+escape_ends_line:
+	MOVQ R11, R12
+	LEAQ (R12)(AX*1), R10        // R10 = output offset of the line end
+
+next_line:
+	CMPQ R12, $16
+	JGE  expand
+
+	// The first character of the next line also escapes tab, space and .
+	MOVOU X0, X1
+	PCMPEQB X13, X1
+	MOVOU X0, X2
+	PCMPEQB X14, X2
+	POR  X2, X1
+	MOVOU X0, X2
+	PCMPEQB X15, X2
+	POR  X2, X1
+	PMOVMSKB X1, R13
+	BTL  R12, R13
+	JCC  expand
+	BTSL R12, DX
+	JMP  expand
+
+no_line_end:
+	MOVQ $32, R12
+
+expand:
+	// Low 8 lanes
+	MOVOU X0, X4
+	MOVL DX, CX
+	ANDL $0xFF, CX
+	EXPAND(expand_lo, expand_lo_done)
+	MOVOU X5, (DI)
+	LEAQ 8(R11), AX
+
+	// High 8 lanes
+	MOVOU X0, X4
+	PSRLDQ $8, X4
+	MOVL DX, CX
+	SHRL $8, CX
+	EXPAND(expand_hi, expand_hi_done)
+	MOVOU X5, (DI)(AX*1)
+	LEAQ 8(AX)(R11*1), AX        // AX = bytes written
 	ADDQ $16, SI
-	ADDQ $16, AX
-	SUBQ $16, CX
+
+	CMPQ R12, $32
+	JNE  line_end
+	ADDQ AX, R10
+	ADDQ AX, DI
+	JMP  simd_loop
+
+line_end:
+	CMPQ R12, $16
+	JNE  crlf
+	MOVQ R9, R10                 // the line is full, the next chunk starts with CRLF
+	ADDQ AX, DI
+	JMP  simd_loop
+
+crlf:
+	// Move the next line's bytes up to insert CRLF
+	LEAQ (DI)(R10*1), R13
+	MOVOU (R13), X1
+	MOVOU 16(R13), X2
+	MOVW $0x0a0d, (R13)
+	MOVOU X1, 2(R13)
+	MOVOU X2, 18(R13)
+	LEAQ 2(DI)(AX*1), DI
+	SUBQ R10, AX
+	MOVQ AX, R10
 	JMP  simd_loop
 
-byte_tail:
-	TESTQ CX, CX
-	JZ   done
-	MOVBLZX (SI), DX
-	ADDL $42, DX
-	ANDL $0xFF, DX               // truncate to byte
-	TESTL DX, DX                 // NUL?
-	JZ   done
-	CMPL DX, $13                 // CR?
-	JEQ  done
-	CMPL DX, $10                 // LF?
-	JEQ  done
-	CMPL DX, $61                 // =?
-	JEQ  done
-
-	MOVB DL, (DI)
-	INCQ DI
-	INCQ SI
+done:
+	MOVQ dst_base+0(FP), AX
+	SUBQ AX, DI
+	MOVQ DI, nDst+64(FP)
+	MOVQ src_base+24(FP), AX
+	SUBQ AX, SI
+	MOVQ SI, nSrc+72(FP)
+	MOVQ R10, newCol+80(FP)
+	RET
+
+// EXPAND8 encodes the 8 lanes of X starting at lane SHIFT of the chunk, with
+// the escaped lanes in DX, to AX(DI) and advances AX past them. OFF holds the
+// offset of the lanes in X for PSHUFB. The escaped lanes are repeated by
+// PSHUFB with indices from ·encodeExpand, the first copy is replaced by "="
+// and 64 is added to the second.
+// Clobbers CX, R11, X2, X3 and X4.
+#define EXPAND8(X, SHIFT, OFF) \
+	MOVL      DX, CX;                    \
+	SHRL      $SHIFT, CX;                \
+	ANDL      $0xFF, CX;                 \
+	MOVL      CX, R11;                   \
+	SHLL      $5, R11;                   \
+	VMOVDQU   (R14)(R11*1), X2;          \
+	VPADDB    OFF, X2, X2;               \
+	VPSHUFB   X2, X, X2;                 \
+	VMOVDQU   16(R14)(R11*1), X3;        \
+	VPSLLDQ   $1, X3, X4;                \
+	VPAND     X7, X4, X4;                \
+	VPADDB    X4, X2, X2;                \
+	VPBLENDVB X3, X12, X2, X2;           \
+	VMOVDQU   X2, (DI)(AX*1);            \
+	POPCNTL   CX, CX;                    \
+	LEAQ      8(AX)(CX*1), AX
+
+// func encodeAVX2(dst, src []byte, lineSize, col int) (nDst, nSrc, newCol int)
+//
+// AVX2 version of encodeSSE2 working on 32-byte chunks, see there for how a
+// chunk is encoded. lineSize must be at least 64, so a chunk holds at most
+// one line end. Encoding stops when fewer than 32 bytes are left in src, or
+// 160 in dst.
+//
+// Register allocation:
+//   DI = dst write pointer, SI = src read pointer
+//   BX = last src chunk start, R8 = last dst chunk start
+//   R9 = lineSize, R10 = column, R14 = ·encodeExpand
+//   DX = escaped lanes of the chunk
+//   R12 = lane starting the next line, 64 when the line doesn't end
+//   AX, CX, R11, R13 = temporaries
+//   Y0 = encoded chunk, Y1-Y4, Y6 = temporaries, Y5 = splat(8), Y7 = splat(64)
+//   Y8 = splat(42), Y9 = zero, Y10 = splat(\r), Y11 = splat(\n)
+//   Y12 = splat(=), Y13 = splat(\t), Y14 = splat(space), Y15 = splat(.)
+TEXT ·encodeAVX2(SB), NOSPLIT, $0-88
+	MOVQ dst_base+0(FP), DI
+	MOVQ dst_len+8(FP), R8
+	LEAQ -160(DI)(R8*1), R8
+	MOVQ src_base+24(FP), SI
+	MOVQ src_len+32(FP), BX
+	LEAQ -32(SI)(BX*1), BX
+	MOVQ lineSize+48(FP), R9
+	MOVQ col+56(FP), R10
+	LEAQ ·encodeExpand(SB), R14
+
+	// Splat constant vectors
+	MOVQ $0x0808080808080808, DX
+	MOVQ DX, X5
+	VPBROADCASTQ X5, Y5
+	MOVQ $0x4040404040404040, DX
+	MOVQ DX, X7
+	VPBROADCASTQ X7, Y7
+	MOVQ $0x2A2A2A2A2A2A2A2A, DX
+	MOVQ DX, X8
+	VPBROADCASTQ X8, Y8
+	VPXOR Y9, Y9, Y9
+	MOVQ $0x0D0D0D0D0D0D0D0D, DX
+	MOVQ DX, X10
+	VPBROADCASTQ X10, Y10
+	MOVQ $0x0A0A0A0A0A0A0A0A, DX
+	MOVQ DX, X11
+	VPBROADCASTQ X11, Y11
+	MOVQ $0x3D3D3D3D3D3D3D3D, DX
+	MOVQ DX, X12
+	VPBROADCASTQ X12, Y12
+	MOVQ $0x0909090909090909, DX
+	MOVQ DX, X13
+	VPBROADCASTQ X13, Y13
+	MOVQ $0x2020202020202020, DX
+	MOVQ DX, X14
+	VPBROADCASTQ X14, Y14
+	MOVQ $0x2E2E2E2E2E2E2E2E, DX
+	MOVQ DX, X15
+	VPBROADCASTQ X15, Y15
+
+simd_loop:
+	CMPQ SI, BX
+	JHI  done
+	CMPQ DI, R8
+	JHI  done
+
+	// Load 32 src bytes and add 42 (encoded = src + 42)
+	VMOVDQU (SI), Y0
+	VPADDB Y8, Y0, Y0
+
+	// Escape NUL, CR, LF and =
+	VPCMPEQB Y9, Y0, Y1
+	VPCMPEQB Y10, Y0, Y2
+	VPOR Y2, Y1, Y1
+	VPCMPEQB Y11, Y0, Y2
+	VPOR Y2, Y1, Y1
+	VPCMPEQB Y12, Y0, Y2
+	VPOR Y2, Y1, Y1
+	VPMOVMSKB Y1, DX
+
+	// A full line is ended before the chunk
+	CMPQ R10, R9
+	JLT  started
+	MOVW $0x0a0d, (DI)
+	ADDQ $2, DI
+	XORQ R10, R10
+
+started:
+	TESTQ R10, R10
+	JNZ  columns
+
+	// The first character of a line also escapes tab, space and .
+	VPCMPEQB Y13, Y0, Y1
+	VPCMPEQB Y14, Y0, Y2
+	VPOR Y2, Y1, Y1
+	VPCMPEQB Y15, Y0, Y2
+	VPOR Y2, Y1, Y1
+	VPMOVMSKB Y1, AX
+	ANDL $1, AX
+	ORL  AX, DX
+
+columns:
+	MOVQ R9, CX
+	SUBQ R10, CX
+	DECQ CX                      // CX = columns left before the last one
+	MOVQ $64, R12
+	CMPQ CX, $64
+	JGE  expand                  // even if every byte is escaped
+
+	// Count the escapes that start before the last column
+	XORQ AX, AX                  // AX = escapes in the line
+	XORQ R11, R11                // R11 = lane after the last of them
+	MOVL DX, R12
+walk:
+	TESTL R12, R12
+	JZ   walked
+	BSFL R12, R13
+	ADDQ AX, R13                 // column of the escape, relative to the chunk
+	CMPQ R13, CX
+	JGE  walked
+	SUBQ AX, R13
+	INCQ R13
+	MOVQ R13, R11
 	INCQ AX
-	DECQ CX
-	JMP  byte_tail
+	LEAL -1(R12), R13
+	ANDL R13, R12
+	JMP  walk
+
+walked:
+	MOVQ CX, R12
+	SUBQ AX, R12                 // R12 = lane in the last column, unless an escape ends the line
+	CMPQ R11, R12
+	JGT  escape_ends_line
+	CMPQ R12, $32
+	JGE  no_line_end
+
+	// The last character of a line also escapes tab and space
+	VPCMPEQB Y13, Y0, Y1
+	VPCMPEQB Y14, Y0, Y2
+	VPOR Y2, Y1, Y1
+	VPMOVMSKB Y1, R13
+	BTL  R12, R13
+	JCC  last_done
+	BTSL R12, DX
+
+last_done:
+	LEAQ 1(R12)(AX*1), R10
+	BTL  R12, DX
+	ADCQ $0, R10                 // R10 = output offset of the line end
+	INCQ R12
+	JMP  next_line
+
+escape_ends_line:
+	MOVQ R11, R12
+	LEAQ (R12)(AX*1), R10        // R10 = output offset of the line end
+
+next_line:
+	CMPQ R12, $32
+	JGE  expand
+
+	// The first character of the next line also escapes tab, space and .
+	VPCMPEQB Y13, Y0, Y1
+	VPCMPEQB Y14, Y0, Y2
+	VPOR Y2, Y1, Y1
+	VPCMPEQB Y15, Y0, Y2
+	VPOR Y2, Y1, Y1
+	VPMOVMSKB Y1, R13
+	BTL  R12, R13
+	JCC  expand
+	BTSL R12, DX
+	JMP  expand
+
+no_line_end:
+	MOVQ $64, R12
+
+expand:
+	XORQ AX, AX
+	VEXTRACTI128 $1, Y0, X1
+	EXPAND8(X0, 0, X9)
+	EXPAND8(X0, 8, X5)
+	EXPAND8(X1, 16, X9)
+	EXPAND8(X1, 24, X5)          // AX = bytes written
+	ADDQ $32, SI
+
+	CMPQ R12, $64
+	JNE  line_end
+	ADDQ AX, R10
+	ADDQ AX, DI
+	JMP  simd_loop
+
+line_end:
+	CMPQ R12, $32
+	JNE  crlf
+	MOVQ R9, R10                 // the line is full, the next chunk starts with CRLF
+	ADDQ AX, DI
+	JMP  simd_loop
+
+crlf:
+	// Move the next line's bytes up to insert CRLF
+	LEAQ (DI)(R10*1), R13
+	VMOVDQU (R13), Y1
+	VMOVDQU 32(R13), Y2
+	MOVW $0x0a0d, (R13)
+	VMOVDQU Y1, 2(R13)
+	VMOVDQU Y2, 34(R13)
+	LEAQ 2(DI)(AX*1), DI
+	SUBQ R10, AX
+	MOVQ AX, R10
+	JMP  simd_loop
 
 done:
-	MOVQ AX, ret+48(FP)
+	VZEROUPPER
+	MOVQ dst_base+0(FP), AX
+	SUBQ AX, DI
+	MOVQ DI, nDst+64(FP)
+	MOVQ src_base+24(FP), AX
+	SUBQ AX, SI
+	MOVQ SI, nSrc+72(FP)
+	MOVQ R10, newCol+80(FP)
 	RET
@@ -1,13 +1,12 @@
-//go:build arm64
+//go:build arm64 && !purego
 
 package rapidyenc
 
 var useSIMDEncode = true
 
-// encodeFast adds 42 to each byte in src and stores to dst,
-// stopping at the first byte whose encoded form needs escaping
-// (NUL, CR, LF, or '='). Returns the number of bytes processed.
-// Uses NEON SIMD for 16-byte chunks when no escaping is needed.
+// encodeFast encodes src into dst like encodeGeneric using NEON SIMD, with
+// escapes and line endings handled in the vector. It stops when fewer than 16
+// bytes are left in src and returns the bytes written, consumed and the new column.
 //
 //go:noescape
-func encodeFast(dst, src []byte) int
+func encodeFast(dst, src []byte, lineSize, col int) (nDst, nSrc, newCol int)
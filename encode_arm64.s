@@ -1,75 +1,251 @@
+//go:build !purego
+
 #include "textflag.h"
 
-// func encodeFast(dst, src []byte) int
+// MOVEMASK sets the low 16 bits of R to the lanes of the compare result V
+// that are set, like PMOVMSKB. T is clobbered.
+#define MOVEMASK(V, T, R) \
+	VAND  V31.B16, V.B16, T.B16; \
+	VADDP T.B16, T.B16, T.B16;   \
+	VADDP T.B16, T.B16, T.B16;   \
+	VADDP T.B16, T.B16, T.B16;   \
+	VMOV  T.H[0], R
+
+// SPACE_MASK sets R to the tab and space lanes of the encoded chunk.
+#define SPACE_MASK(R) \
+	VCMEQ V25.B16, V0.B16, V1.B16; \
+	VCMEQ V26.B16, V0.B16, V2.B16; \
+	VORR  V2.B16, V1.B16, V1.B16;  \
+	MOVEMASK(V1, V2, R)
+
+// SPACE_DOT_MASK sets R to the tab, space and "." lanes of the encoded chunk.
+#define SPACE_DOT_MASK(R) \
+	VCMEQ V25.B16, V0.B16, V1.B16; \
+	VCMEQ V26.B16, V0.B16, V2.B16; \
+	VORR  V2.B16, V1.B16, V1.B16;  \
+	VCMEQ V27.B16, V0.B16, V2.B16; \
+	VORR  V2.B16, V1.B16, V1.B16;  \
+	MOVEMASK(V1, V2, R)
+
+// EXPAND8 encodes the 8 lanes of V0 starting at lane SHIFT, with the
+// escaped lanes in R6, to R9(R0) and advances R9 past them. OFF holds the
+// offset of the lanes for TBL. It follows EXPAND8 in encode_amd64.s: the
+// escaped lanes are repeated by TBL with indices from ·encodeExpand, the
+// first copy is replaced by "=" and 64 is added to the second. The number of
+// escapes is where the 0x80 indices of unused lanes start in the high half.
+// Clobbers R8, R12, R13, V2, V3 and V4.
+#define EXPAND8(SHIFT, OFF) \
+	LSR   $SHIFT, R6, R8;                  \
+	AND   $0xFF, R8;                       \
+	ADD   R8<<5, R14, R13;                 \
+	VLD1  (R13), [V2.B16, V3.B16];         \
+	MOVD  8(R13), R12;                     \
+	VADD  OFF.B16, V2.B16, V2.B16;         \
+	VTBL  V2.B16, [V0.B16], V2.B16;        \
+	VEXT  $15, V3.B16, V21.B16, V4.B16;    \
+	VAND  V28.B16, V4.B16, V4.B16;         \
+	VADD  V4.B16, V2.B16, V2.B16;          \
+	VBIT  V3.B16, V24.B16, V2.B16;         \
+	ADD   R9, R0, R13;                     \
+	VST1  [V2.B16], (R13);                 \
+	AND   $0x8080808080808080, R12, R12;   \
+	RBIT  R12, R12;                        \
+	CLZ   R12, R12;                        \
+	ADD   R12>>3, R9, R9;                  \
+	ADD   $8, R9
+
+// func encodeFast(dst, src []byte, lineSize, col int) (nDst, nSrc, newCol int)
+//
+// NEON yEnc encoder. It follows the SSE2 version in encode_amd64.s, see
+// there for how each 16-byte chunk is encoded and the limits on its input.
 //
-// Adds 42 to each byte in src and stores to dst, stopping at the first byte
-// whose encoded form needs escaping (NUL=0, CR=13, LF=10, '='=61).
-// Returns the number of bytes processed.
-TEXT ·encodeFast(SB), NOSPLIT, $0-56
-	MOVD dst_base+0(FP), R0      // dst pointer
-	MOVD src_base+24(FP), R1     // src pointer
-	MOVD src_len+32(FP), R2      // src length
-	MOVD $0, R3                  // bytes processed
+// Register allocation:
+//   R0 = dst write pointer, R1 = src read pointer
+//   R2 = last src chunk start, R3 = last dst chunk start
+//   R4 = lineSize, R5 = column, R14 = ·encodeExpand
+//   R6 = escaped lanes of the chunk
+//   R7 = lane starting the next line, 32 when the line doesn't end
+//   R8-R13 = temporaries
+//   V0 = encoded chunk, V1-V4 = temporaries, V20 = splat(42), V21 = zero
+//   V22 = splat(\r), V23 = splat(\n), V24 = splat(=), V25 = splat(\t)
+//   V26 = splat(space), V27 = splat(.), V28 = splat(64), V29 = splat(8)
+//   V31 = ·bitWeights
+TEXT ·encodeFast(SB), NOSPLIT, $0-88
+	MOVD dst_base+0(FP), R0
+	MOVD dst_len+8(FP), R3
+	ADD  R0, R3, R3
+	SUB  $80, R3
+	MOVD src_base+24(FP), R1
+	MOVD src_len+32(FP), R2
+	ADD  R1, R2, R2
+	SUB  $16, R2
+	MOVD lineSize+48(FP), R4
+	MOVD col+56(FP), R5
+	MOVD $·encodeExpand(SB), R14
+	MOVD $·bitWeights(SB), R8
+	VLD1 (R8), [V31.B16]
 
 	// Set up constant vectors
-	VMOVI $42, V25.B16           // add value
-	VEOR  V29.B16, V29.B16, V29.B16 // NUL (0)
-	VMOVI $13, V28.B16           // CR
-	VMOVI $10, V27.B16           // LF
-	VMOVI $61, V26.B16           // =
+	VMOVI $42, V20.B16
+	VEOR  V21.B16, V21.B16, V21.B16
+	VMOVI $13, V22.B16
+	VMOVI $10, V23.B16
+	VMOVI $61, V24.B16
+	VMOVI $9, V25.B16
+	VMOVI $32, V26.B16
+	VMOVI $46, V27.B16
+	VMOVI $64, V28.B16
+	VMOVI $8, V29.B16
 
 simd_loop:
-	CMP  $16, R2
-	BLT  byte_tail
+	CMP  R2, R1
+	BHI  done
+	CMP  R3, R0
+	BHI  done
 
-	// Load 16 src bytes and add 42
+	// Load 16 src bytes and add 42 (encoded = src + 42)
 	VLD1 (R1), [V0.B16]
-	VADD V25.B16, V0.B16, V1.B16    // V1 = encoded (src + 42)
-
-	// Check for specials in encoded output
-	VCMEQ V29.B16, V1.B16, V2.B16   // == NUL
-	VCMEQ V28.B16, V1.B16, V3.B16   // == CR
-	VCMEQ V27.B16, V1.B16, V4.B16   // == LF
-	VCMEQ V26.B16, V1.B16, V5.B16   // == =
-	VORR  V2.B16, V3.B16, V6.B16
-	VORR  V4.B16, V5.B16, V7.B16
-	VORR  V6.B16, V7.B16, V6.B16
-
-	// Check if any byte is non-zero
-	VMOV V6.D[0], R4
-	VMOV V6.D[1], R5
-	ORR  R4, R5, R4
-	CBNZ R4, byte_tail
-
-	// Fast path: no specials, store encoded bytes
-	VST1 [V1.B16], (R0)
-	ADD  $16, R0
+	VADD V20.B16, V0.B16, V0.B16
+
+	// Escape NUL, CR, LF and =
+	VCMEQ V21.B16, V0.B16, V1.B16
+	VCMEQ V22.B16, V0.B16, V2.B16
+	VORR  V2.B16, V1.B16, V1.B16
+	VCMEQ V23.B16, V0.B16, V2.B16
+	VORR  V2.B16, V1.B16, V1.B16
+	VCMEQ V24.B16, V0.B16, V2.B16
+	VORR  V2.B16, V1.B16, V1.B16
+	MOVEMASK(V1, V2, R6)
+
+	// A full line is ended before the chunk
+	CMP  R4, R5
+	BLT  started
+	MOVD $0x0a0d, R8
+	MOVH R8, (R0)
+	ADD  $2, R0
+	MOVD $0, R5
+
+started:
+	CBNZ R5, columns
+
+	// The first character of a line also escapes tab, space and .
+	SPACE_DOT_MASK(R8)
+	AND  $1, R8
+	ORR  R8, R6
+
+columns:
+	SUB  R5, R4, R8
+	SUB  $1, R8                  // R8 = columns left before the last one
+	MOVD $32, R7
+	CMP  $32, R8
+	BGE  expand                  // even if every byte is escaped
+
+	// Count the escapes that start before the last column
+	MOVD $0, R9                  // R9 = escapes in the line
+	MOVD $0, R10                 // R10 = lane after the last of them
+	MOVD R6, R11
+
+walk:
+	CBZ  R11, walked
+	RBIT R11, R12
+	CLZ  R12, R12
+	ADD  R9, R12, R13            // column of the escape, relative to the chunk
+	CMP  R8, R13
+	BGE  walked
+	ADD  $1, R12, R10
+	ADD  $1, R9
+	SUB  $1, R11, R13
+	AND  R13, R11, R11
+	B    walk
+
+walked:
+	SUB  R9, R8, R7              // R7 = lane in the last column, unless an escape ends the line
+	CMP  R7, R10
+	BGT  escape_ends_line
+	CMP  $16, R7
+	BGE  no_line_end
+
+	// The last character of a line also escapes tab and space
+	SPACE_MASK(R13)
+	LSR  R7, R13, R13
+	AND  $1, R13
+	LSL  R7, R13, R13
+	ORR  R13, R6
+
+	LSR  R7, R6, R13
+	AND  $1, R13
+	ADD  R7, R13, R13
+	ADD  R9, R13, R13
+	ADD  $1, R13, R5             // R5 = output offset of the line end
+	ADD  $1, R7
+	B    next_line
+
+escape_ends_line:
+	MOVD R10, R7
+	ADD  R9, R7, R5              // R5 = output offset of the line end
+
+next_line:
+	CMP  $16, R7
+	BGE  expand
+
+	// The first character of the next line also escapes tab, space and .
+	SPACE_DOT_MASK(R13)
+	LSR  R7, R13, R13
+	AND  $1, R13
+	LSL  R7, R13, R13
+	ORR  R13, R6
+	B    expand
+
+no_line_end:
+	MOVD $32, R7
+
+expand:
+	// Most chunks have nothing to escape and are stored as they are
+	CBNZ R6, escape
+	VST1 [V0.B16], (R0)
+	MOVD $16, R9
+	B    expanded
+
+escape:
+	MOVD $0, R9
+	EXPAND8(0, V21)
+	EXPAND8(8, V29)              // R9 = bytes written
+
+expanded:
 	ADD  $16, R1
-	ADD  $16, R3
-	SUB  $16, R2
+
+	CMP  $32, R7
+	BNE  line_end
+	ADD  R9, R5
+	ADD  R9, R0
 	B    simd_loop
 
-byte_tail:
-	// Process bytes one at a time until an escape is needed
-	CBZ  R2, done
-	MOVBU (R1), R4
-	ADD  $42, R4, R5             // encoded = src + 42 (truncated to byte)
-	AND  $0xFF, R5, R5
-	CBZ  R5, done                // NUL?
-	CMP  $13, R5                 // CR?
-	BEQ  done
-	CMP  $10, R5                 // LF?
-	BEQ  done
-	CMP  $61, R5                 // =?
-	BEQ  done
-
-	MOVB R5, (R0)
-	ADD  $1, R0
-	ADD  $1, R1
-	ADD  $1, R3
-	SUB  $1, R2
-	B    byte_tail
+line_end:
+	CMP  $16, R7
+	BNE  crlf
+	MOVD R4, R5                  // the line is full, the next chunk starts with CRLF
+	ADD  R9, R0
+	B    simd_loop
+
+crlf:
+	// Move the next line's bytes up to insert CRLF
+	ADD  R5, R0, R13
+	VLD1 (R13), [V1.B16, V2.B16]
+	MOVD $0x0a0d, R12
+	MOVH R12, (R13)
+	ADD  $2, R13
+	VST1 [V1.B16, V2.B16], (R13)
+	ADD  R9, R0
+	ADD  $2, R0
+	SUB  R5, R9, R5
+	B    simd_loop
 
 done:
-	MOVD R3, ret+48(FP)
+	MOVD dst_base+0(FP), R8
+	SUB  R8, R0, R0
+	MOVD R0, nDst+64(FP)
+	MOVD src_base+24(FP), R8
+	SUB  R8, R1, R1
+	MOVD R1, nSrc+72(FP)
+	MOVD R5, newCol+80(FP)
 	RET
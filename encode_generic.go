@@ -1,5 +1,9 @@
 package rapidyenc
 
+// minSIMDLineSize is the shortest line length encodeFast supports, a 16-byte
+// chunk must not span more than one line end.
+const minSIMDLineSize = 32
+
 // escapeLUT maps each byte to its encoded form (byte+42), or 0 if the byte needs escaping.
 // "Needs escaping" means the encoded form is NUL, CR, LF, TAB, SPACE, '=', or '.'.
 var escapeLUT [256]byte
@@ -36,10 +40,18 @@ func encodeGeneric(lineSize int, src, dst []byte, col int) (int, int) {
 		return 0, col
 	}
 
-	p := 0    // destination offset
-	i := 0    // source offset
+	p := 0     // destination offset
+	i := 0     // source offset
 	var c byte // current source byte
 
+	// SIMD fast path: encode all but the last few bytes, the rest is done below
+	if useSIMDEncode && lineSize >= minSIMDLineSize {
+		p, i, col = encodeFast(dst, src, lineSize, col)
+		if i == len(src) {
+			return p, col
+		}
+	}
+
 	if col == 0 {
 		// First character of first line
 		c = src[i]
@@ -59,16 +71,6 @@ func encodeGeneric(lineSize int, src, dst []byte, col int) (int, int) {
 	for i < len(src) {
 		// Main line body
 		for col < lineSize-1 && i < len(src) {
-			// SIMD fast path: encode multiple non-escaped bytes at once
-			if useSIMDEncode && col+16 <= lineSize-1 && len(src)-i >= 16 {
-				n := encodeFast(dst[p:], src[i:])
-				if n > 0 {
-					p += n
-					i += n
-					col += n
-					continue
-				}
-			}
 			c = src[i]
 			i++
 			escaped := escapeLUT[c]
@@ -102,6 +104,8 @@ func encodeGeneric(lineSize int, src, dst []byte, col int) (int, int) {
 				dst[p] = c + 42
 				p++
 			}
+			// The line is full, a following call must start with CRLF
+			col = lineSize
 		}
 
 		if i >= len(src) {
@@ -1,7 +1,7 @@
-//go:build !(amd64 || arm64)
+//go:build purego || !(amd64 || arm64)
 
 package rapidyenc
 
 var useSIMDEncode = false
 
-func encodeFast(dst, src []byte) int { return 0 }
+func encodeFast(dst, src []byte, lineSize, col int) (nDst, nSrc, newCol int) { return 0, 0, col }
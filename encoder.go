@@ -6,6 +6,8 @@ import (
 	"hash"
 	"hash/crc32"
 	"io"
+	"slices"
+	"strings"
 	"sync"
 
 	"golang.org/x/sync/errgroup"
@@ -15,12 +17,20 @@ type Encoder struct {
 	w        io.Writer
 	m        Meta
 	hWritten bool
+	closed   bool
 
 	hash       hash.Hash32
 	lineLength int
+	extra      []HeaderKeyValue
 	column     int
 	processed  int64
 
+	// fileCRC is the CRC32 of the first fileSize bytes of the file, folded in
+	// from each part encoded in order. fileSize is -1 when parts were skipped.
+	fileCRC    uint32
+	fileSize   int64
+	fileCRCSet bool
+
 	buf     []byte
 	endByte []byte
 
@@ -28,16 +38,56 @@ type Encoder struct {
 	hashErrs errgroup.Group
 }
 
+const (
+	defaultLineLength = 128
+	minLineLength     = 2
+	maxLineLength     = 997 // escaping the last column adds a byte, keeping lines within 998 bytes
+)
+
+var errLineLength = fmt.Errorf("line length must be between %d and %d", minLineLength, maxLineLength)
+
+// EncoderOption configures optional [Encoder] settings.
+type EncoderOption func(e *Encoder) error
+
+// WithLineLength sets the number of encoded bytes per line, reported by the
+// "=ybegin line=" header. The default is 128.
+func WithLineLength(n int) EncoderOption {
+	return func(e *Encoder) error {
+		if n < minLineLength || n > maxLineLength {
+			return fmt.Errorf("[rapidyenc] invalid line length %d: %w", n, errLineLength)
+		}
+		e.lineLength = n
+		return nil
+	}
+}
+
+// WithExtra writes the given key=value pairs after the standard keys of their
+// header line, before "name=" on the "=ybegin" line. Keys the encoder writes
+// itself are rejected. Pass [DecodedMeta.Extra] to keep the keys of a decoded
+// article.
+func WithExtra(kvs ...HeaderKeyValue) EncoderOption {
+	return func(e *Encoder) error {
+		e.extra = slices.Clone(kvs)
+		return nil
+	}
+}
+
 // NewEncoder returns a new [Encoder].
 // Writes to the returned writer are yEnc encoded and written to w.
 //
 // It is the caller's responsibility to call Close on the [Encoder] when done.
-func NewEncoder(w io.Writer, m Meta) (e *Encoder, err error) {
+func NewEncoder(w io.Writer, m Meta, opts ...EncoderOption) (e *Encoder, err error) {
 	e = new(Encoder)
-	e.lineLength = 128
+	e.lineLength = defaultLineLength
 	e.hash = crc32.NewIEEE()
 	e.endByte = make([]byte, 0, 1)
 
+	for _, opt := range opts {
+		if err := opt(e); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := e.Reset(w, m); err != nil {
 		return nil, err
 	}
@@ -52,21 +102,47 @@ func (e *Encoder) Reset(w io.Writer, meta Meta) error {
 	if err := meta.validate(); err != nil {
 		return err
 	}
+	if err := validateExtra(e.extra, meta.SinglePart); err != nil {
+		return err
+	}
 
 	e.writeMu.Lock()
 	defer e.writeMu.Unlock()
 
+	// Keep folding the whole file CRC only when meta is the next part of the same file
+	prev := e.m
+	if meta.Offset == 0 || meta.FileName != prev.FileName || meta.FileSize != prev.FileSize ||
+		meta.TotalParts != prev.TotalParts || meta.PartNumber != prev.PartNumber+1 {
+		e.fileCRC = 0
+		e.fileSize = 0
+	}
+
 	e.w = w
 	e.m = meta
 	e.hWritten = false
+	e.closed = false
 	e.hash.Reset()
 	e.endByte = e.endByte[:0]
+	e.column = 0
 	e.processed = 0
 	e.hashErrs = errgroup.Group{}
+	e.fileCRCSet = false
 
 	return nil
 }
 
+// SetFileCRC sets the CRC32 of the whole file, written as "=yend crc32=" when
+// encoding the last part. This is only needed when the parts of a file are not
+// all encoded in order by the same [Encoder], which otherwise computes it by
+// combining the CRC32 of each part, see [CombineCRC32].
+func (e *Encoder) SetFileCRC(crc uint32) {
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+
+	e.fileCRC = crc
+	e.fileCRCSet = true
+}
+
 var errWriterNil = errors.New("writer is nil")
 
 // Write writes a yEnc encoded form of p to the underlying [io.Writer]. The
@@ -75,6 +151,9 @@ func (e *Encoder) Write(p []byte) (n int, err error) {
 	e.writeMu.Lock()
 	defer e.writeMu.Unlock()
 
+	if e.closed {
+		return 0, ErrEncoderClosed
+	}
 	if e.w == nil {
 		return 0, errWriterNil
 	}
@@ -117,6 +196,7 @@ func (e *Encoder) Write(p []byte) (n int, err error) {
 
 		buf := e.buf
 
+		checkKernel()
 		length, newCol := encodeGeneric(e.lineLength, p, buf, e.column)
 		e.column = newCol
 
@@ -142,14 +222,18 @@ func (e *Encoder) Write(p []byte) (n int, err error) {
 }
 
 // Close flushes any pending output from the encoder and writes the trailing header.
-// It is an error to call Write after calling Close.
+// Calling Write or Close after Close returns [ErrEncoderClosed].
 func (e *Encoder) Close() error {
 	e.writeMu.Lock()
 	defer e.writeMu.Unlock()
 
+	if e.closed {
+		return ErrEncoderClosed
+	}
 	if e.w == nil {
 		return errWriterNil
 	}
+	e.closed = true
 	defer func() { e.w = nil }()
 
 	if len(e.endByte) > 0 {
@@ -159,15 +243,12 @@ func (e *Encoder) Close() error {
 	}
 
 	if !e.m.Raw {
-		if _, err := fmt.Fprintf(e.w, "\r\n=yend size=%d part=%d pcrc32=%08x\r\n", e.m.PartSize, e.m.PartNumber, e.hash.Sum32()); err != nil {
+		if err := e.writeTrailer(); err != nil {
 			return err
 		}
 
 		if e.processed != e.m.PartSize {
-			return fmt.Errorf(
-				"[rapidyenc] encode header has part size %d but actually encoded %d bytes",
-				e.m.PartSize, e.processed,
-			)
+			return &SizeMismatchError{Expected: e.m.PartSize, Actual: e.processed}
 		}
 	}
 
@@ -177,14 +258,22 @@ func (e *Encoder) Close() error {
 // Encode yEnc encodes the src buffer without adding any =y headers
 //
 // Deprecated: use Encoder as an io.WriteCloser which includes yEnc headers
-func Encode(src []byte) ([]byte, error) {
+func Encode(src []byte, opts ...EncoderOption) ([]byte, error) {
 	if len(src) == 0 {
 		return nil, errors.New("empty source")
 	}
 
-	dst := make([]byte, MaxLength(len(src), 128))
+	e := Encoder{lineLength: defaultLineLength}
+	for _, opt := range opts {
+		if err := opt(&e); err != nil {
+			return nil, err
+		}
+	}
+
+	dst := make([]byte, MaxLength(len(src), e.lineLength))
 
-	length, _ := encodeGeneric(128, src, dst, 0)
+	checkKernel()
+	length, _ := encodeGeneric(e.lineLength, src, dst, 0)
 
 	// Escape trailing space/tab for standalone encoding
 	if length > 0 {
@@ -199,15 +288,56 @@ func Encode(src []byte) ([]byte, error) {
 	return dst[:length], nil
 }
 
+// writeTrailer writes the "=yend" line, including the whole file crc32 on the last part when known.
+func (e *Encoder) writeTrailer() error {
+	partCRC := e.hash.Sum32()
+
+	if e.m.SinglePart {
+		_, err := fmt.Fprintf(e.w, "\r\n=yend size=%d crc32=%08x%s\r\n", e.m.PartSize, partCRC, e.extraKeys(HeaderEnd))
+		return err
+	}
+
+	if !e.fileCRCSet {
+		if e.fileSize == e.m.Offset && e.processed == e.m.PartSize {
+			e.fileCRC = CombineCRC32(e.fileCRC, partCRC, e.processed)
+			e.fileSize += e.processed
+		} else {
+			e.fileSize = -1
+		}
+	}
+
+	if e.m.PartNumber == e.m.TotalParts && (e.fileCRCSet || e.fileSize == e.m.FileSize) {
+		_, err := fmt.Fprintf(e.w, "\r\n=yend size=%d part=%d pcrc32=%08x crc32=%08x%s\r\n", e.m.PartSize, e.m.PartNumber, partCRC, e.fileCRC, e.extraKeys(HeaderEnd))
+		return err
+	}
+
+	_, err := fmt.Fprintf(e.w, "\r\n=yend size=%d part=%d pcrc32=%08x%s\r\n", e.m.PartSize, e.m.PartNumber, partCRC, e.extraKeys(HeaderEnd))
+	return err
+}
+
+// extraKeys formats the [WithExtra] pairs of a header line, each preceded by a space.
+func (e *Encoder) extraKeys(kind HeaderKind) string {
+	var b strings.Builder
+	for _, kv := range e.extra {
+		if kv.Header == kind {
+			b.WriteString(" " + kv.Key + "=" + kv.Value)
+		}
+	}
+	return b.String()
+}
+
 func (e *Encoder) writeHeader() (int, error) {
 	if e.hWritten {
 		return 0, nil
 	}
 
 	e.hWritten = true
+	if e.m.SinglePart {
+		return fmt.Fprintf(e.w, "=ybegin line=%d size=%d%s name=%s\r\n", e.lineLength, e.m.FileSize, e.extraKeys(HeaderBegin), e.m.FileName)
+	}
 	return fmt.Fprintf(
 		e.w,
-		"=ybegin part=%d total=%d line=%d size=%d name=%s\r\n=ypart begin=%d end=%d\r\n",
-		e.m.PartNumber, e.m.TotalParts, e.lineLength, e.m.FileSize, e.m.FileName, e.m.Begin(), e.m.End(),
+		"=ybegin part=%d total=%d line=%d size=%d%s name=%s\r\n=ypart begin=%d end=%d%s\r\n",
+		e.m.PartNumber, e.m.TotalParts, e.lineLength, e.m.FileSize, e.extraKeys(HeaderBegin), e.m.FileName, e.m.Begin(), e.m.End(), e.extraKeys(HeaderPart),
 	)
 }
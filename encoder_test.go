@@ -3,10 +3,13 @@ package rapidyenc
 import (
 	"bytes"
 	"crypto/rand"
+	"fmt"
 	"github.com/stretchr/testify/require"
 	"hash/crc32"
 	"io"
+	mathrand "math/rand/v2"
 	"testing"
+	"testing/iotest"
 )
 
 type encoderCase struct {
@@ -59,6 +62,241 @@ func TestEncoderSimple(t *testing.T) {
 	}
 }
 
+func TestEncoderLineLength(t *testing.T) {
+	random := make([]byte, 64*1024)
+	_, err := rand.Read(random)
+	require.NoError(t, err)
+
+	// Every byte value that needs escaping anywhere on a line
+	escapes := bytes.Repeat([]byte{0xD6, 0xE0, 0xE3, 0x13, 0xDF, 0xF6, 0x04}, 1000)
+
+	for _, lineLength := range []int{2, 3, 16, 17, 31, 64, 100, 128, 256, 997} {
+		for name, raw := range map[string][]byte{"random": random, "escapes": escapes} {
+			t.Run(fmt.Sprintf("%d/%s", lineLength, name), func(t *testing.T) {
+				meta := Meta{
+					FileName:   "filename",
+					FileSize:   int64(len(raw)),
+					PartSize:   int64(len(raw)),
+					PartNumber: 1,
+					TotalParts: 1,
+				}
+
+				encoded := new(bytes.Buffer)
+				enc, err := NewEncoder(encoded, meta, WithLineLength(lineLength))
+				require.NoError(t, err)
+				_, err = io.Copy(enc, iotest.HalfReader(bytes.NewReader(raw)))
+				require.NoError(t, err)
+				require.NoError(t, enc.Close())
+
+				lines := bytes.Split(encoded.Bytes(), []byte("\r\n"))
+				require.Equal(t, fmt.Sprintf("=ybegin part=1 total=1 line=%d size=%d name=filename", lineLength, len(raw)), string(lines[0]))
+				body := lines[2 : len(lines)-2]
+				for i, line := range body {
+					require.LessOrEqual(t, len(line), lineLength+1, "line %d", i)
+					if i < len(body)-1 {
+						require.GreaterOrEqual(t, len(line), lineLength-1, "line %d", i)
+					}
+				}
+
+				dec := NewDecoder(bytes.NewReader(encoded.Bytes()))
+				decoded := new(bytes.Buffer)
+				_, err = io.Copy(decoded, dec)
+				require.NoError(t, err)
+				require.Equal(t, raw, decoded.Bytes())
+
+				// Output is independent of the kernel used
+				standalone, err := Encode(raw, WithLineLength(lineLength))
+				require.NoError(t, err)
+				require.LessOrEqual(t, len(standalone), MaxLength(len(raw), lineLength))
+
+				old := useSIMDEncode
+				useSIMDEncode = false
+				defer func() { useSIMDEncode = old }()
+				generic, err := Encode(raw, WithLineLength(lineLength))
+				require.NoError(t, err)
+				require.Equal(t, generic, standalone)
+
+				// Output is independent of how the input is split across writes
+				oneByte := new(bytes.Buffer)
+				enc, err = NewEncoder(oneByte, meta, WithLineLength(lineLength))
+				require.NoError(t, err)
+				_, err = io.Copy(enc, iotest.OneByteReader(bytes.NewReader(raw)))
+				require.NoError(t, err)
+				require.NoError(t, enc.Close())
+				require.Equal(t, encoded.String(), oneByte.String())
+			})
+		}
+	}
+}
+
+func TestEncodeFastParity(t *testing.T) {
+	if len(simdKernels()) == 0 {
+		t.Skip("no SIMD encoder")
+	}
+
+	// Bytes encoding to NUL, \r, \n, "=", tab, space and "."
+	alphabet := []byte{0xd6, 0xe3, 0xe0, 0x13, 0xdf, 0xf6, 0x04}
+	seed := make([]byte, 32)
+	_, err := rand.Read(seed)
+	require.NoError(t, err)
+	rng := mathrand.New(mathrand.NewChaCha8([32]byte(seed)))
+
+	encode := func(simd bool, src []byte, chunks []int, lineSize, col int) ([]byte, []int) {
+		useSIMDEncode = simd
+		dst := make([]byte, MaxLength(len(src), lineSize)+lineSize)
+		var trace []int
+		p, i := 0, 0
+		for _, n := range chunks {
+			var nd int
+			nd, col = encodeGeneric(lineSize, src[i:i+n], dst[p:], col)
+			p += nd
+			i += n
+			trace = append(trace, nd, col)
+		}
+		return dst[:p], trace
+	}
+
+	forEachSIMDKernel(t, func(t *testing.T) {
+		for range 2000 {
+			// Mostly plain bytes, with bytes to escape at a varying density
+			src := make([]byte, rng.IntN(600))
+			density := rng.IntN(20) + 1
+			for i := range src {
+				if rng.IntN(density) == 0 {
+					src[i] = alphabet[rng.IntN(len(alphabet))]
+				} else {
+					src[i] = byte(rng.IntN(256))
+				}
+			}
+			var chunks []int
+			for n := 0; n < len(src); {
+				c := min(rng.IntN(200)+1, len(src)-n)
+				chunks = append(chunks, c)
+				n += c
+			}
+			lineSize := []int{2, 31, 32, 33, 64, 128, 997}[rng.IntN(7)]
+			col := rng.IntN(lineSize + 1)
+
+			generic, genericTrace := encode(false, src, chunks, lineSize, col)
+			simd, simdTrace := encode(true, src, chunks, lineSize, col)
+			require.Equal(t, genericTrace, simdTrace, "lineSize %d col %d src %x", lineSize, col, src)
+			require.Equal(t, generic, simd, "lineSize %d col %d src %x", lineSize, col, src)
+		}
+	})
+}
+
+func TestEncoderInvalidLineLength(t *testing.T) {
+	meta := Meta{
+		FileName:   "filename",
+		FileSize:   1,
+		PartSize:   1,
+		PartNumber: 1,
+		TotalParts: 1,
+	}
+
+	for _, lineLength := range []int{-1, 0, 1, 998} {
+		_, err := NewEncoder(io.Discard, meta, WithLineLength(lineLength))
+		require.ErrorIs(t, err, errLineLength)
+	}
+}
+
+func TestEncoderFileCRC(t *testing.T) {
+	raw := make([]byte, 10_000)
+	_, err := rand.Read(raw)
+	require.NoError(t, err)
+
+	const partSize = 3000
+	meta := func(part int) Meta {
+		offset := int64((part - 1) * partSize)
+		return Meta{
+			FileName:   "filename",
+			FileSize:   int64(len(raw)),
+			PartNumber: int64(part),
+			TotalParts: 4,
+			Offset:     offset,
+			PartSize:   min(partSize, int64(len(raw))-offset),
+		}
+	}
+	fileCRC := fmt.Sprintf(" crc32=%08x\r\n", crc32.ChecksumIEEE(raw))
+
+	t.Run("sequential", func(t *testing.T) {
+		var enc *Encoder
+		for part := 1; part <= 4; part++ {
+			m := meta(part)
+			encoded := new(bytes.Buffer)
+			if enc == nil {
+				enc, err = NewEncoder(encoded, m)
+				require.NoError(t, err)
+			} else {
+				require.NoError(t, enc.Reset(encoded, m))
+			}
+
+			_, err = enc.Write(raw[m.Offset:m.End()])
+			require.NoError(t, err)
+			require.NoError(t, enc.Close())
+
+			require.Equal(t, part == 4, bytes.HasSuffix(encoded.Bytes(), []byte(fileCRC)), "part %d", part)
+		}
+	})
+
+	t.Run("explicit", func(t *testing.T) {
+		m := meta(4)
+		encoded := new(bytes.Buffer)
+		enc, err := NewEncoder(encoded, m)
+		require.NoError(t, err)
+		_, err = enc.Write(raw[m.Offset:m.End()])
+		require.NoError(t, err)
+		require.NoError(t, enc.Close())
+		require.NotContains(t, encoded.String(), " crc32=")
+
+		encoded.Reset()
+		require.NoError(t, enc.Reset(encoded, m))
+		enc.SetFileCRC(crc32.ChecksumIEEE(raw))
+		_, err = enc.Write(raw[m.Offset:m.End()])
+		require.NoError(t, err)
+		require.NoError(t, enc.Close())
+		require.True(t, bytes.HasSuffix(encoded.Bytes(), []byte(fileCRC)))
+	})
+
+	t.Run("other file", func(t *testing.T) {
+		a := Meta{FileName: "a", FileSize: 200, PartNumber: 1, TotalParts: 2, PartSize: 100}
+		encoded := new(bytes.Buffer)
+		enc, err := NewEncoder(encoded, a)
+		require.NoError(t, err)
+		_, err = enc.Write(raw[:100])
+		require.NoError(t, err)
+		require.NoError(t, enc.Close())
+
+		b := Meta{FileName: "b", FileSize: 200, PartNumber: 2, TotalParts: 2, Offset: 100, PartSize: 100}
+		encoded.Reset()
+		require.NoError(t, enc.Reset(encoded, b))
+		_, err = enc.Write(raw[100:200])
+		require.NoError(t, err)
+		require.NoError(t, enc.Close())
+		require.NotContains(t, encoded.String(), " crc32=")
+	})
+
+	t.Run("short part", func(t *testing.T) {
+		m := meta(1)
+		encoded := new(bytes.Buffer)
+		enc, err := NewEncoder(encoded, m)
+		require.NoError(t, err)
+		_, err = enc.Write(raw[m.Offset : m.End()-1])
+		require.NoError(t, err)
+		require.Error(t, enc.Close())
+
+		for part := 2; part <= 4; part++ {
+			m = meta(part)
+			encoded.Reset()
+			require.NoError(t, enc.Reset(encoded, m))
+			_, err = enc.Write(raw[m.Offset:m.End()])
+			require.NoError(t, err)
+			require.NoError(t, enc.Close())
+		}
+		require.NotContains(t, encoded.String(), " crc32=")
+	})
+}
+
 func BenchmarkEncoder(b *testing.B) {
 	raw := make([]byte, 1024*1024)
 	_, err := rand.Read(raw)
@@ -77,14 +315,45 @@ func BenchmarkEncoder(b *testing.B) {
 	enc, err := NewEncoder(io.Discard, meta)
 	require.NoError(b, err)
 
-	b.ResetTimer()
-	for b.Loop() {
-		_, err = io.Copy(enc, r)
-		require.NoError(b, err)
-		err = enc.Close()
-		require.NoError(b, err)
-		_, err = r.Seek(0, io.SeekStart)
-		require.NoError(b, err)
-		enc.Reset(io.Discard, meta)
+	benchSIMDKernels(b, func(b *testing.B) {
+		for b.Loop() {
+			_, err = io.Copy(enc, r)
+			require.NoError(b, err)
+			err = enc.Close()
+			require.NoError(b, err)
+			_, err = r.Seek(0, io.SeekStart)
+			require.NoError(b, err)
+			enc.Reset(io.Discard, meta)
+		}
+	})
+}
+
+func TestEncoderErrors(t *testing.T) {
+	meta := Meta{
+		FileName:   "filename",
+		FileSize:   10,
+		PartSize:   10,
+		PartNumber: 1,
+		TotalParts: 1,
 	}
+
+	enc, err := NewEncoder(io.Discard, meta)
+	require.NoError(t, err)
+	_, err = enc.Write(make([]byte, 9))
+	require.NoError(t, err)
+
+	var sizeErr *SizeMismatchError
+	require.ErrorAs(t, enc.Close(), &sizeErr)
+	require.Equal(t, int64(10), sizeErr.Expected)
+	require.Equal(t, int64(9), sizeErr.Actual)
+
+	_, err = enc.Write([]byte("foo"))
+	require.ErrorIs(t, err, ErrEncoderClosed)
+	require.ErrorIs(t, enc.Close(), ErrEncoderClosed)
+
+	// Reset makes the encoder usable again
+	require.NoError(t, enc.Reset(io.Discard, meta))
+	_, err = enc.Write(make([]byte, 10))
+	require.NoError(t, err)
+	require.NoError(t, enc.Close())
 }
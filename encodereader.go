@@ -0,0 +1,79 @@
+package rapidyenc
+
+import (
+	"bytes"
+	"io"
+)
+
+// encodeChunkSize is the amount of data read from the source per encoded chunk.
+const encodeChunkSize = 32 * 1024
+
+// encodeReader is the pull-mode counterpart of [Encoder].
+type encodeReader struct {
+	src   io.Reader
+	enc   *Encoder
+	out   bytes.Buffer
+	chunk []byte
+
+	// err is returned once out is drained, io.EOF after the trailer was produced
+	err error
+}
+
+// NewEncodeReader returns an [io.Reader] that yields a yEnc encoded article
+// of the data read from src: the "=ybegin" and "=ypart" headers, the encoded
+// body and the "=yend" trailer. Data is read from src and encoded on demand.
+//
+// Reading returns the same errors as [NewEncoder] and [Encoder.Close], such
+// as when src yields more or fewer than m.PartSize bytes.
+func NewEncodeReader(src io.Reader, m Meta, opts ...EncoderOption) io.Reader {
+	r := &encodeReader{src: src}
+	r.enc, r.err = NewEncoder(&r.out, m, opts...)
+	return r
+}
+
+func (r *encodeReader) Read(p []byte) (int, error) {
+	for r.out.Len() == 0 && r.err == nil {
+		r.encodeChunk()
+	}
+
+	if r.out.Len() > 0 {
+		return r.out.Read(p)
+	}
+
+	return 0, r.err
+}
+
+// encodeChunk encodes the next chunk of src into out, closing the
+// encoder to produce the trailer once src is exhausted.
+func (r *encodeReader) encodeChunk() {
+	if r.chunk == nil {
+		r.chunk = make([]byte, encodeChunkSize)
+	}
+
+	for range maxConsecutiveEmptyReads {
+		n, err := r.src.Read(r.chunk)
+		if n > 0 {
+			if _, werr := r.enc.Write(r.chunk[:n]); werr != nil {
+				r.err = werr
+				return
+			}
+		}
+
+		switch err {
+		case nil:
+			if n == 0 {
+				continue
+			}
+		case io.EOF:
+			r.err = io.EOF
+			if cerr := r.enc.Close(); cerr != nil {
+				r.err = cerr
+			}
+		default:
+			r.err = err
+		}
+		return
+	}
+
+	r.err = io.ErrNoProgress
+}
@@ -0,0 +1,85 @@
+package rapidyenc
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeReader(t *testing.T) {
+	raw := make([]byte, 100_000)
+	_, err := rand.Read(raw)
+	require.NoError(t, err)
+
+	meta := Meta{
+		FileName:   "filename",
+		FileSize:   int64(len(raw)),
+		PartSize:   int64(len(raw)),
+		PartNumber: 1,
+		TotalParts: 1,
+	}
+
+	encoded, err := io.ReadAll(iotest.OneByteReader(NewEncodeReader(iotest.HalfReader(bytes.NewReader(raw)), meta)))
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(encoded, []byte("=ybegin part=1 total=1 line=128 size=100000 name=filename\r\n=ypart begin=1 end=100000\r\n")))
+	crc := crc32.ChecksumIEEE(raw)
+	require.True(t, bytes.HasSuffix(encoded, fmt.Appendf(nil, " pcrc32=%08x crc32=%08x\r\n", crc, crc)))
+
+	decoded := new(bytes.Buffer)
+	dec := NewDecoder(NewEncodeReader(bytes.NewReader(raw), meta))
+	_, err = io.Copy(decoded, dec)
+	require.NoError(t, err)
+	require.Equal(t, raw, decoded.Bytes())
+	require.Equal(t, "filename", dec.Meta.FileName)
+}
+
+func TestEncodeReaderSizeMismatch(t *testing.T) {
+	meta := Meta{
+		FileName:   "filename",
+		FileSize:   10,
+		PartSize:   10,
+		PartNumber: 1,
+		TotalParts: 1,
+	}
+
+	for _, size := range []int{9, 11} {
+		enc, err := NewEncoder(io.Discard, meta)
+		require.NoError(t, err)
+		_, err = enc.Write(make([]byte, size))
+		require.NoError(t, err)
+		closeErr := enc.Close()
+		require.Error(t, closeErr)
+
+		_, err = io.ReadAll(NewEncodeReader(bytes.NewReader(make([]byte, size)), meta))
+		require.Equal(t, closeErr, err)
+	}
+}
+
+func TestEncodeReaderInvalidMeta(t *testing.T) {
+	_, err := io.ReadAll(NewEncodeReader(bytes.NewReader([]byte("foo")), Meta{}))
+	require.ErrorIs(t, err, errFileNameEmpty)
+}
+
+// emptyReader returns (0, nil) forever.
+type emptyReader struct{}
+
+func (emptyReader) Read([]byte) (int, error) { return 0, nil }
+
+func TestEncodeReaderNoProgress(t *testing.T) {
+	meta := Meta{
+		FileName:   "filename",
+		FileSize:   10,
+		PartSize:   10,
+		PartNumber: 1,
+		TotalParts: 1,
+	}
+
+	_, err := io.ReadAll(NewEncodeReader(emptyReader{}, meta))
+	require.ErrorIs(t, err, io.ErrNoProgress)
+}
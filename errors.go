@@ -0,0 +1,64 @@
+package rapidyenc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DecodeError describes a problem found while decoding an article. Err wraps
+// one of [ErrDataCorruption], [ErrDataMissing], [ErrCrcMismatch] or
+// [ErrInvalidHeader], so [errors.Is] can be used to tell them apart.
+type DecodeError struct {
+	Err error
+
+	Offset int64  // Offset in the encoded data of the line where the problem was detected
+	Line   int64  // Line number in the encoded data, starting at 1
+	Header string // Header line involved without its line ending, if any
+
+	// Set when the decoded size doesn't match the headers
+	ExpectedSize int64
+	ActualSize   int64
+
+	// Set when the decoded data doesn't match a CRC32 from the headers
+	ExpectedCRC uint32
+	ActualCRC   uint32
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("%v (line %d, offset %d)", e.Err, e.Line, e.Offset)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// ErrEncoderClosed is returned by [Encoder.Write] and [Encoder.Close] after the [Encoder] has been closed.
+var ErrEncoderClosed = errors.New("encoder is closed")
+
+// ErrDecodeWriterClosed is returned by [DecodeWriter.Write] and [DecodeWriter.Close] after the [DecodeWriter] has been closed.
+var ErrDecodeWriterClosed = errors.New("decode writer is closed")
+
+// MetaError reports an invalid [Meta] field.
+type MetaError struct {
+	Field string // Name of the Meta field, such as "PartSize"
+	Err   error
+}
+
+func (e *MetaError) Error() string {
+	return fmt.Sprintf("[rapidyenc] invalid meta %s: %v", e.Field, e.Err)
+}
+
+func (e *MetaError) Unwrap() error {
+	return e.Err
+}
+
+// SizeMismatchError is returned by [Encoder.Close] when the number of bytes
+// written differs from [Meta.PartSize].
+type SizeMismatchError struct {
+	Expected int64
+	Actual   int64
+}
+
+func (e *SizeMismatchError) Error() string {
+	return fmt.Sprintf("[rapidyenc] encode header has part size %d but actually encoded %d bytes", e.Expected, e.Actual)
+}
@@ -0,0 +1,123 @@
+package rapidyenc
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeError(t *testing.T) {
+	const begin = "=ybegin part=1 total=1 line=128 size=3 name=a"
+	const part = "=ypart begin=1 end=3"
+
+	cases := []struct {
+		name     string
+		article  string
+		sentinel error
+		strict   bool
+		expected DecodeError
+	}{
+		{
+			name:     "size mismatch",
+			article:  article(begin, part, "=yend size=4 part=1"),
+			sentinel: ErrDataCorruption,
+			expected: DecodeError{Offset: 74, Line: 4, Header: "=yend size=4 part=1", ExpectedSize: 4, ActualSize: 3},
+		},
+		{
+			// Decoded newlines must not be counted when decoding in place
+			name:     "size mismatch after newlines",
+			article:  "=ybegin part=1 total=1 line=128 size=8 name=a\r\n=ypart begin=1 end=8\r\n4444\r\n4444\r\n=yend size=9 part=1\r\n",
+			sentinel: ErrDataCorruption,
+			expected: DecodeError{Offset: 81, Line: 5, Header: "=yend size=9 part=1", ExpectedSize: 9, ActualSize: 8},
+		},
+		{
+			// Long enough for the line endings to be counted by the SIMD decoders
+			name:     "size mismatch after long body",
+			article:  "=ybegin part=1 total=1 line=64 size=2560 name=a\r\n=ypart begin=1 end=2560\r\n" + strings.Repeat(strings.Repeat("4", 64)+"\r\n", 40) + "=yend size=2561 part=1\r\n",
+			sentinel: ErrDataCorruption,
+			expected: DecodeError{Offset: 2714, Line: 43, Header: "=yend size=2561 part=1", ExpectedSize: 2561, ActualSize: 2560},
+		},
+		{
+			name:     "part crc mismatch",
+			article:  article(begin, part, "=yend size=3 part=1 pcrc32=deadbeef"),
+			sentinel: ErrCrcMismatch,
+			expected: DecodeError{Offset: 74, Line: 4, Header: "=yend size=3 part=1 pcrc32=deadbeef", ExpectedCRC: 0xdeadbeef, ActualCRC: 0x352441c2},
+		},
+		{
+			name:     "file crc mismatch",
+			article:  article(begin, part, "=yend size=3 part=1 pcrc32=352441c2 crc32=1"),
+			sentinel: ErrCrcMismatch,
+			expected: DecodeError{Offset: 74, Line: 4, Header: "=yend size=3 part=1 pcrc32=352441c2 crc32=1", ExpectedCRC: 1, ActualCRC: 0x352441c2},
+		},
+		{
+			name:     "missing yend",
+			article:  begin + "\r\n" + part + "\r\n\x8b\x8c\x8d\r\n.\r\n",
+			sentinel: ErrDataCorruption,
+			expected: DecodeError{Offset: 77, Line: 4},
+		},
+		{
+			name:     "missing ybegin",
+			article:  "foo\r\nbar\r\n",
+			sentinel: ErrDataMissing,
+			expected: DecodeError{Offset: 10, Line: 2},
+		},
+		{
+			name:     "strict header",
+			article:  article(begin, "=ypart begin=1 end=x", "=yend size=3 part=1"),
+			sentinel: ErrInvalidHeader,
+			strict:   true,
+			expected: DecodeError{Offset: 47, Line: 2, Header: "=ypart begin=1 end=x"},
+		},
+		{
+			name:     "uuencoded",
+			article:  "begin 644 a\r\n#86)C\r\n\x01\r\n",
+			sentinel: ErrDataCorruption,
+			expected: DecodeError{Offset: 20, Line: 3},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			strictness := Lenient
+			if tc.strict {
+				strictness = Strict
+			}
+
+			dec := NewDecoder(strings.NewReader(tc.article), WithStrictness(strictness))
+			_, err := io.Copy(io.Discard, dec)
+			require.ErrorIs(t, err, tc.sentinel)
+
+			var de *DecodeError
+			require.ErrorAs(t, err, &de)
+			require.ErrorIs(t, de.Err, tc.sentinel)
+			de.Err = nil
+			require.Equal(t, tc.expected, *de)
+
+			// The same error is reported through DecodeWriter, one byte at a time decodes in place
+			w := NewDecodeWriter(io.Discard, WithStrictness(strictness))
+			err = nil
+			for i := 0; i < len(tc.article) && err == nil; i++ {
+				_, err = w.Write([]byte{tc.article[i]})
+			}
+			if err == nil {
+				err = w.Close()
+			}
+			require.ErrorAs(t, err, &de)
+			de.Err = nil
+			require.Equal(t, tc.expected, *de)
+		})
+	}
+}
+
+func TestDecodeErrorMessage(t *testing.T) {
+	err := &DecodeError{
+		Err:    fmt.Errorf("[rapidyenc] expected size 4 but got 3: %w", ErrDataCorruption),
+		Offset: 74,
+		Line:   4,
+	}
+	require.Equal(t, "[rapidyenc] expected size 4 but got 3: data corruption detected (line 4, offset 74)", err.Error())
+	require.ErrorIs(t, err, ErrDataCorruption)
+}
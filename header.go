@@ -0,0 +1,255 @@
+package rapidyenc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// HeaderKind is the type of yEnc header line
+type HeaderKind int
+
+const (
+	HeaderUnknown HeaderKind = iota
+	HeaderBegin              // =ybegin
+	HeaderPart               // =ypart
+	HeaderEnd                // =yend
+)
+
+func (k HeaderKind) String() string {
+	switch k {
+	case HeaderBegin:
+		return "=ybegin"
+	case HeaderPart:
+		return "=ypart"
+	case HeaderEnd:
+		return "=yend"
+	default:
+		return "unknown"
+	}
+}
+
+// HeaderField is a single key=value pair of a yEnc header line.
+// Key and Value refer to the bytes of the parsed line.
+type HeaderField struct {
+	Key   []byte
+	Value []byte
+}
+
+// HeaderLine is a tokenized "=ybegin", "=ypart" or "=yend" line.
+type HeaderLine struct {
+	Kind HeaderKind
+
+	// Fields in the order they appear on the line, including duplicate keys
+	Fields []HeaderField
+}
+
+// HeaderKeyValue is a key=value pair of a yEnc header line that is not
+// otherwise represented in [Meta], such as a private key added by a poster.
+type HeaderKeyValue struct {
+	Header HeaderKind // The line the pair appears on
+	Key    string
+	Value  string
+}
+
+var (
+	ErrInvalidHeader = errors.New("invalid yEnc header")
+
+	errInvalidInt = errors.New("invalid integer")
+	errInvalidCRC = errors.New("invalid crc32")
+)
+
+// ParseHeaderLine tokenizes a yEnc "=ybegin", "=ypart" or "=yend" line.
+//
+// Keys are separated by spaces or tabs, except that "name=" takes the rest of
+// the line, so file names may contain spaces and text that looks like other
+// keys. The name is kept verbatim up to the line ending, other trailing
+// whitespace is ignored.
+//
+// Known numeric keys (size, part, total, line, begin, end) and CRC32 keys
+// (crc32, pcrc32) are validated. Problems are reported as an error wrapping
+// [ErrInvalidHeader], along with every field that could be tokenized.
+func ParseHeaderLine(line []byte) (HeaderLine, error) {
+	return parseHeaderLine(line, nil)
+}
+
+// parseHeaderLine is [ParseHeaderLine] appending the fields to fields, so that
+// the slice can be reused between lines.
+func parseHeaderLine(line []byte, fields []HeaderField) (HeaderLine, error) {
+	h := HeaderLine{Fields: fields}
+
+	line = bytes.TrimRight(line, "\r\n")
+	h.Kind, line = cutHeaderKind(line)
+	if h.Kind == HeaderUnknown {
+		return h, fmt.Errorf("[rapidyenc] line is not a yEnc header: %w", ErrInvalidHeader)
+	}
+
+	var errs []error
+	for {
+		line = bytes.TrimLeft(line, " \t")
+		if len(line) == 0 {
+			break
+		}
+
+		token := line
+		if end := bytes.IndexAny(line, " \t"); end != -1 {
+			token = line[:end]
+		}
+
+		key, value, found := bytes.Cut(token, []byte("="))
+		if !found || len(key) == 0 {
+			errs = append(errs, fmt.Errorf("[rapidyenc] malformed %q in %s line: %w", token, h.Kind, ErrInvalidHeader))
+			line = line[len(token):]
+			continue
+		}
+
+		if string(key) == "name" {
+			// The name is the rest of the line and may contain spaces
+			value = line[len("name="):]
+			if end := bytes.IndexByte(value, 0); end != -1 {
+				value = value[:end]
+			}
+			line = nil
+		} else {
+			line = line[len(token):]
+		}
+
+		h.Fields = append(h.Fields, HeaderField{Key: key, Value: value})
+		if err := validateHeaderField(key, value); err != nil {
+			errs = append(errs, fmt.Errorf("[rapidyenc] %s %s=%q: %w: %w", h.Kind, key, value, err, ErrInvalidHeader))
+		}
+	}
+
+	return h, errors.Join(errs...)
+}
+
+// cutHeaderKind returns the kind of header line and the remainder after the keyword.
+func cutHeaderKind(line []byte) (HeaderKind, []byte) {
+	for _, kind := range []HeaderKind{HeaderBegin, HeaderPart, HeaderEnd} {
+		rest, found := bytes.CutPrefix(line, []byte(kind.String()))
+		if found && (len(rest) == 0 || rest[0] == ' ' || rest[0] == '\t') {
+			return kind, rest
+		}
+	}
+	return HeaderUnknown, line
+}
+
+// isKnownKey reports whether key is represented in [DecodedMeta] for a line of kind.
+func isKnownKey(kind HeaderKind, key []byte) bool {
+	switch kind {
+	case HeaderBegin:
+		switch string(key) {
+		case "part", "total", "line", "size", "name":
+			return true
+		}
+	case HeaderPart:
+		switch string(key) {
+		case "begin", "end":
+			return true
+		}
+	case HeaderEnd:
+		switch string(key) {
+		case "size", "part", "pcrc32", "crc32":
+			return true
+		}
+	}
+	return false
+}
+
+func validateHeaderField(key, value []byte) error {
+	switch string(key) {
+	case "size", "part", "total", "line", "begin", "end":
+		_, err := parseInt(value)
+		return err
+	case "crc32", "pcrc32":
+		_, err := parseCRC(value)
+		return err
+	}
+	return nil
+}
+
+// Value returns the value of the first occurrence of key.
+func (h HeaderLine) Value(key string) ([]byte, bool) {
+	for _, f := range h.Fields {
+		if string(f.Key) == key {
+			return f.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Int returns the integer value of the first occurrence of key.
+// ok is false when the key is missing or the value is not a valid integer.
+func (h HeaderLine) Int(key string) (n int64, ok bool) {
+	value, found := h.Value(key)
+	if !found {
+		return 0, false
+	}
+	n, err := parseInt(value)
+	return n, err == nil
+}
+
+// CRC returns the hexadecimal CRC32 value of the first occurrence of key.
+// ok is false when the key is missing or the value is not valid hexadecimal.
+func (h HeaderLine) CRC(key string) (crc uint32, ok bool) {
+	value, found := h.Value(key)
+	if !found {
+		return 0, false
+	}
+	crc, err := parseCRC(value)
+	return crc, err == nil
+}
+
+// parseInt parses a non-negative decimal integer. Unlike strconv.ParseInt it
+// does not need to convert data to a string, so header parsing doesn't allocate.
+func parseInt(data []byte) (int64, error) {
+	if len(data) == 0 {
+		return 0, errInvalidInt
+	}
+
+	var n int64
+	for _, c := range data {
+		if c < '0' || c > '9' {
+			return 0, errInvalidInt
+		}
+		if n > (math.MaxInt64-int64(c-'0'))/10 {
+			return 0, errInvalidInt
+		}
+		n = n*10 + int64(c-'0')
+	}
+
+	return n, nil
+}
+
+// parseCRC converts a hexadecimal representation of a crc32 hash.
+// Some posters write sign extended 64-bit values, so only the last 8 digits
+// are used, and shorter values are treated as left padded with zeros.
+func parseCRC(data []byte) (uint32, error) {
+	if len(data) == 0 {
+		return 0, errInvalidCRC
+	}
+
+	var crc uint32
+	for _, c := range data[len(data)-min(8, len(data)):] {
+		switch {
+		case c >= '0' && c <= '9':
+			c -= '0'
+		case c >= 'a' && c <= 'f':
+			c -= 'a' - 10
+		case c >= 'A' && c <= 'F':
+			c -= 'A' - 10
+		default:
+			return 0, errInvalidCRC
+		}
+		crc = crc<<4 | uint32(c)
+	}
+
+	for _, c := range data[:len(data)-min(8, len(data))] {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return 0, errInvalidCRC
+		}
+	}
+
+	return crc, nil
+}
@@ -0,0 +1,175 @@
+package rapidyenc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHeaderLineName(t *testing.T) {
+	cases := []struct {
+		raw      string
+		expected string
+	}{
+		{"", ""},
+		{"foo", "foo"},
+		{"name=bar", "name=bar"},
+		{"foo bar", "foo bar"},
+		{"before\x00after", "before"},
+		{"foo size=3 part=9.bin", "foo size=3 part=9.bin"},
+		{"foo\tbar", "foo\tbar"},
+		{"foo  \t", "foo  \t"}, // the name is kept verbatim
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.raw, func(t *testing.T) {
+			b := []byte(fmt.Sprintf("=ybegin part=1 line=128 size=128 name=%s\r\n", tc.raw))
+			h, err := ParseHeaderLine(b)
+			require.NoError(t, err)
+			require.Equal(t, HeaderBegin, h.Kind)
+			name, ok := h.Value("name")
+			require.True(t, ok)
+			require.Equal(t, tc.expected, string(name))
+			size, ok := h.Int("size")
+			require.True(t, ok)
+			require.Equal(t, int64(128), size)
+		})
+	}
+}
+
+func TestParseHeaderLineCRC(t *testing.T) {
+	cases := []struct {
+		raw      string
+		expected uint32
+	}{
+		{"ffffffffa95d3e50", 0xa95d3e50},
+		{"fffffffa95d3e50", 0xa95d3e50},
+		{"ffffffa95d3e50", 0xa95d3e50},
+		{"fffffa95d3e50", 0xa95d3e50},
+		{"ffffa95d3e50", 0xa95d3e50},
+		{"fffa95d3e50", 0xa95d3e50},
+		{"ffa95d3e50", 0xa95d3e50},
+		{"fa95d3e50", 0xa95d3e50},
+		{"a95d3e50", 0xa95d3e50},
+		{"A95D3E50", 0xa95d3e50},
+		{"a95d3e5", 0xa95d3e5},
+		{"a95d3e", 0xa95d3e},
+		{"a95d3", 0xa95d3},
+		{"a95d", 0xa95d},
+		{"a95", 0xa95},
+		{"a9", 0xa9},
+		{"a", 0xa},
+		{"12345678 ", 0x12345678}, // space at end
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.raw, func(t *testing.T) {
+			b := []byte(fmt.Sprintf("=yend size=10 pcrc32=%s", tc.raw))
+			h, err := ParseHeaderLine(b)
+			require.NoError(t, err)
+			crc, ok := h.CRC("pcrc32")
+			require.True(t, ok)
+			require.Equal(t, tc.expected, crc)
+		})
+	}
+}
+
+func TestParseHeaderLineWhitespace(t *testing.T) {
+	h, err := ParseHeaderLine([]byte("=ypart\tbegin=1  \tend=100 \t \r\n"))
+	require.NoError(t, err)
+	require.Equal(t, HeaderPart, h.Kind)
+	require.Len(t, h.Fields, 2)
+
+	begin, ok := h.Int("begin")
+	require.True(t, ok)
+	require.Equal(t, int64(1), begin)
+	end, ok := h.Int("end")
+	require.True(t, ok)
+	require.Equal(t, int64(100), end)
+
+	h, err = ParseHeaderLine([]byte("=yend"))
+	require.NoError(t, err)
+	require.Equal(t, HeaderEnd, h.Kind)
+	require.Empty(t, h.Fields)
+}
+
+func TestParseHeaderLineDuplicateKeys(t *testing.T) {
+	h, err := ParseHeaderLine([]byte("=yend size=10 part=1 size=20 pcrc32=01 crc32=02"))
+	require.NoError(t, err)
+	require.Len(t, h.Fields, 5)
+
+	// The first occurrence wins, all are kept in order
+	size, ok := h.Int("size")
+	require.True(t, ok)
+	require.Equal(t, int64(10), size)
+	require.Equal(t, "size", string(h.Fields[2].Key))
+	require.Equal(t, "20", string(h.Fields[2].Value))
+
+	// pcrc32 must not be confused with crc32
+	crc, ok := h.CRC("crc32")
+	require.True(t, ok)
+	require.Equal(t, uint32(2), crc)
+}
+
+func TestParseHeaderLineErrors(t *testing.T) {
+	cases := []struct {
+		name string
+		raw  string
+	}{
+		{"not a header", "hello world"},
+		{"keyword prefix", "=ybeginning size=1"},
+		{"negative size", "=ybegin size=-1 name=foo"},
+		{"size with letters", "=ybegin size=12ab name=foo"},
+		{"empty size", "=ybegin size= name=foo"},
+		{"size overflow", "=ybegin size=99999999999999999999 name=foo"},
+		{"part not a number", "=ybegin part=x size=1 name=foo"},
+		{"invalid begin", "=ypart begin=1.5 end=10"},
+		{"empty crc", "=yend size=1 pcrc32="},
+		{"crc not hex", "=yend size=1 crc32=zzzzzzzz"},
+		{"crc prefix not hex", "=yend size=1 crc32=xx12345678"},
+		{"token without value", "=ybegin size=1 garbage name=foo"},
+		{"token without key", "=ybegin =1 name=foo"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseHeaderLine([]byte(tc.raw))
+			require.ErrorIs(t, err, ErrInvalidHeader)
+		})
+	}
+}
+
+func TestParseHeaderLinePartial(t *testing.T) {
+	h, err := ParseHeaderLine([]byte("=ybegin part=x total=3 line=128 size=1000 name=foo bar"))
+	require.ErrorIs(t, err, ErrInvalidHeader)
+	require.ErrorContains(t, err, "part")
+
+	_, ok := h.Int("part")
+	require.False(t, ok)
+	total, ok := h.Int("total")
+	require.True(t, ok)
+	require.Equal(t, int64(3), total)
+	name, ok := h.Value("name")
+	require.True(t, ok)
+	require.Equal(t, "foo bar", string(name))
+}
+
+func TestDecodeHeaderTabs(t *testing.T) {
+	raw := []byte("=ybegin\tpart=1\ttotal=1\tline=128\tsize=3\tname=a b\r\n" +
+		"=ypart\tbegin=1\tend=3 \r\n" +
+		"\x8b\x8c\x8d\r\n" +
+		"=yend\tsize=3\tpart=1\tpcrc32=352441c2 \t\r\n")
+
+	dec := NewDecoder(bytes.NewReader(raw))
+	out, err := io.ReadAll(dec)
+	require.NoError(t, err)
+	require.Equal(t, "abc", string(out))
+	require.Equal(t, "a b", dec.Meta.FileName)
+	require.Equal(t, int64(3), dec.Meta.FileSize)
+	require.Equal(t, int64(1), dec.Meta.PartNumber)
+	require.True(t, dec.Meta.HasPartCRC)
+	require.Equal(t, uint32(0x352441c2), dec.Meta.PartCRC)
+}
@@ -1,10 +1,15 @@
 package rapidyenc
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
 
 // Meta is the result of parsing the yEnc headers (ybegin, ypart, yend)
 type Meta struct {
 	Raw        bool // Encode body without the yEnc headers
+	SinglePart bool // Single-part layout: no "=ypart" line, nor part= and total= keys
 	FileName   string
 	FileSize   int64 // Total size of the file
 	PartNumber int64
@@ -23,42 +28,89 @@ func (m Meta) End() int64 {
 	return m.Offset + m.PartSize
 }
 
+// WholeFile reports whether the part contains the entire file
+func (m Meta) WholeFile() bool {
+	return m.SinglePart || (m.Offset == 0 && m.PartSize == m.FileSize)
+}
+
 type DecodedMeta struct {
 	Meta
 	Hash uint32 // CRC32 hash of the decoded data
+
+	PartCRC       uint32 // "=yend pcrc32=" CRC32 of the part, set when HasPartCRC
+	HasPartCRC    bool
+	FileCRC       uint32 // "=yend crc32=" CRC32 of the whole file, set when HasFileCRC
+	HasFileCRC    bool
+	LineLength    int64 // "=ybegin line=" encoded line length, set when HasLineLength
+	HasLineLength bool
+
+	// Extra key=value pairs not otherwise represented, in the order they appear
+	// on the header lines. Pass them to [WithExtra] to encode them again.
+	Extra []HeaderKeyValue
 }
 
 var (
+	errSinglePart    = errors.New("single-part must contain the whole file")
 	errFileNameEmpty = errors.New("file name is empty")
 	errFileSize      = errors.New("file size is less than or equal to zero")
 	errPartNumber    = errors.New("part number is less than or equal to zero")
 	errTotalParts    = errors.New("total parts is less than part number")
 	errOffset        = errors.New("offset is less than zero")
 	errPartSize      = errors.New("part size is less than or equal to zero")
+	errPartRange     = errors.New("offset plus part size is greater than file size")
+	errExtraHeader   = errors.New("extra header key is invalid")
 )
 
+// validate returns a [*MetaError] naming the first invalid field.
 func (m Meta) validate() error {
 	if m.Raw {
 		return nil
 	}
 	if len(m.FileName) == 0 {
-		return errFileNameEmpty
+		return &MetaError{Field: "FileName", Err: errFileNameEmpty}
 	}
 	if m.FileSize <= 0 {
-		return errFileSize
+		return &MetaError{Field: "FileSize", Err: errFileSize}
+	}
+	if m.SinglePart {
+		// part= and total= are not written, so PartNumber and TotalParts are not required
+		if m.Offset != 0 || m.PartSize != m.FileSize {
+			return &MetaError{Field: "SinglePart", Err: errSinglePart}
+		}
+		return nil
 	}
 	if m.PartNumber <= 0 {
-		return errPartNumber
+		return &MetaError{Field: "PartNumber", Err: errPartNumber}
 	}
 	if m.TotalParts < m.PartNumber {
-		return errTotalParts
+		return &MetaError{Field: "TotalParts", Err: errTotalParts}
 	}
 	if m.Offset < 0 {
-		return errOffset
+		return &MetaError{Field: "Offset", Err: errOffset}
 	}
 	if m.PartSize <= 0 {
-		return errPartSize
+		return &MetaError{Field: "PartSize", Err: errPartSize}
+	}
+	if m.Offset+m.PartSize > m.FileSize {
+		return &MetaError{Field: "PartSize", Err: errPartRange}
 	}
 
 	return nil
 }
+
+// validateExtra checks that the extra pairs can be written and parsed back
+// unchanged, without overriding the keys the encoder writes itself.
+func validateExtra(extra []HeaderKeyValue, singlePart bool) error {
+	for _, kv := range extra {
+		switch {
+		case kv.Header != HeaderBegin && kv.Header != HeaderPart && kv.Header != HeaderEnd,
+			kv.Header == HeaderPart && singlePart,
+			len(kv.Key) == 0,
+			strings.ContainsAny(kv.Key, "= \t\r\n\x00"),
+			strings.ContainsAny(kv.Value, " \t\r\n\x00"),
+			isKnownKey(kv.Header, []byte(kv.Key)):
+			return fmt.Errorf("[rapidyenc] %s %s=%q: %w", kv.Header, kv.Key, kv.Value, errExtraHeader)
+		}
+	}
+	return nil
+}
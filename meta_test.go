@@ -1,8 +1,10 @@
 package rapidyenc
 
 import (
-	"github.com/stretchr/testify/require"
+	"io"
 	"testing"
+
+	"github.com/stretchr/testify/require"
 )
 
 func TestMetaValidation(t *testing.T) {
@@ -28,4 +30,79 @@ func TestMetaValidation(t *testing.T) {
 	m.PartSize = 100
 
 	require.NoError(t, m.validate())
+
+	m.Offset = 901
+	require.ErrorIs(t, m.validate(), errPartRange)
+	m.Offset = 900
+	require.NoError(t, m.validate())
+}
+
+func TestMetaError(t *testing.T) {
+	cases := []struct {
+		meta  Meta
+		field string
+	}{
+		{Meta{}, "FileName"},
+		{Meta{FileName: "a"}, "FileSize"},
+		{Meta{FileName: "a", FileSize: 10}, "PartNumber"},
+		{Meta{FileName: "a", FileSize: 10, PartNumber: 2, TotalParts: 1}, "TotalParts"},
+		{Meta{FileName: "a", FileSize: 10, PartNumber: 1, TotalParts: 1, Offset: -1}, "Offset"},
+		{Meta{FileName: "a", FileSize: 10, PartNumber: 1, TotalParts: 1}, "PartSize"},
+		{Meta{FileName: "a", FileSize: 10, PartNumber: 1, TotalParts: 1, Offset: 5, PartSize: 6}, "PartSize"},
+		{Meta{FileName: "a", FileSize: 10, PartSize: 5, SinglePart: true}, "SinglePart"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.field, func(t *testing.T) {
+			_, err := NewEncoder(io.Discard, tc.meta)
+			var metaErr *MetaError
+			require.ErrorAs(t, err, &metaErr)
+			require.Equal(t, tc.field, metaErr.Field)
+			require.Contains(t, err.Error(), tc.field)
+		})
+	}
+}
+
+func TestMetaValidationSinglePart(t *testing.T) {
+	m := Meta{SinglePart: true, FileName: "foobar", FileSize: 1000, PartSize: 100}
+	require.ErrorIs(t, m.validate(), errSinglePart)
+
+	m.PartSize = 1000
+	m.Offset = 1
+	require.ErrorIs(t, m.validate(), errSinglePart)
+
+	m.Offset = 0
+	require.NoError(t, m.validate())
+}
+
+func TestValidateExtra(t *testing.T) {
+	for _, kv := range []HeaderKeyValue{
+		{Header: HeaderUnknown, Key: "foo", Value: "bar"},
+		{Header: HeaderBegin, Key: "", Value: "bar"},
+		{Header: HeaderBegin, Key: "a=b", Value: "bar"},
+		{Header: HeaderBegin, Key: "a b", Value: "bar"},
+		{Header: HeaderEnd, Key: "foo", Value: "a b"},
+		{Header: HeaderEnd, Key: "foo", Value: "a\r\n"},
+		{Header: HeaderBegin, Key: "name", Value: "bar"},
+		{Header: HeaderBegin, Key: "part", Value: "1"},
+		{Header: HeaderPart, Key: "end", Value: "1"},
+		{Header: HeaderEnd, Key: "crc32", Value: "00000000"},
+	} {
+		require.ErrorIs(t, validateExtra([]HeaderKeyValue{kv}, false), errExtraHeader, kv)
+	}
+
+	extra := []HeaderKeyValue{{Header: HeaderPart, Key: "foo", Value: ""}, {Header: HeaderEnd, Key: "name", Value: "x"}}
+	require.NoError(t, validateExtra(extra, false))
+	require.ErrorIs(t, validateExtra(extra, true), errExtraHeader)
+}
+
+func TestEncoderExtraError(t *testing.T) {
+	m := Meta{SinglePart: true, FileName: "foobar", FileSize: 100, PartSize: 100}
+	_, err := NewEncoder(io.Discard, m, WithExtra(HeaderKeyValue{Header: HeaderBegin, Key: "part", Value: "1"}))
+	require.ErrorIs(t, err, errExtraHeader)
+
+	enc, err := NewEncoder(io.Discard, Meta{FileName: "foobar", FileSize: 100, PartSize: 100, PartNumber: 1, TotalParts: 1},
+		WithExtra(HeaderKeyValue{Header: HeaderPart, Key: "foo", Value: "bar"}))
+	require.NoError(t, err)
+	require.ErrorIs(t, enc.Reset(io.Discard, m), errExtraHeader)
 }
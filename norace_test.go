@@ -0,0 +1,5 @@
+//go:build !race
+
+package rapidyenc
+
+const raceEnabled = false
@@ -1,14 +1,77 @@
 package rapidyenc
 
-import "runtime"
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
 
 // Version returns the version of the rapidyenc library.
 func Version() string {
 	return "2.0.0"
 }
 
+// KernelEnv is the environment variable selecting the kernel at startup, like
+// [SetKernel]. Unknown names are ignored.
+const KernelEnv = "RAPIDYENC_KERNEL"
+
+var (
+	// ErrUnknownKernel is returned by [SetKernel] for a name not listed by [Kernels].
+	ErrUnknownKernel = errors.New("unknown kernel")
+
+	// ErrKernelSelfTest is returned by [SetKernel] when the kernel fails its
+	// self-test, the generic kernel is used instead.
+	ErrKernelSelfTest = errors.New("kernel failed its self-test")
+)
+
+var (
+	kernelMu     sync.Mutex
+	kernelTested atomic.Bool // the selected kernel passed its self-test
+)
+
+func init() {
+	kernelFromEnv()
+}
+
+// kernelFromEnv selects the kernel named by KernelEnv, if any.
+func kernelFromEnv() {
+	if name := os.Getenv(KernelEnv); slices.Contains(Kernels(), name) {
+		selectKernel(name)
+	}
+}
+
+// Kernels returns the names of the kernels supported on this CPU, "generic"
+// first. Assembly kernels are not available when built with the purego tag.
+func Kernels() []string {
+	return append([]string{"generic"}, simdKernels()...)
+}
+
+// SetKernel selects the kernel used for decoding and encoding by one of the
+// names returned by [Kernels], "generic" rules out the assembly. It takes
+// precedence over [KernelEnv]. SetKernel must not be called while encoding
+// or decoding.
+func SetKernel(name string) error {
+	if !slices.Contains(Kernels(), name) {
+		return fmt.Errorf("[rapidyenc] %q: %w", name, ErrUnknownKernel)
+	}
+
+	kernelMu.Lock()
+	defer kernelMu.Unlock()
+	selectKernel(name)
+	if !testKernel() {
+		return fmt.Errorf("[rapidyenc] %s: %w", name, ErrKernelSelfTest)
+	}
+	return nil
+}
+
 // DecodeKernel returns the name of the implementation being used for decode operations.
 func DecodeKernel() string {
+	checkKernel()
 	if useSIMDDecode {
 		return archKernel()
 	}
@@ -17,19 +80,70 @@ func DecodeKernel() string {
 
 // EncodeKernel returns the name of the implementation being used for encode operations.
 func EncodeKernel() string {
+	checkKernel()
 	if useSIMDEncode {
 		return archKernel()
 	}
 	return "generic"
 }
 
-func archKernel() string {
-	switch runtime.GOARCH {
-	case "arm64":
-		return "NEON"
-	case "amd64":
-		return "SSE2"
-	default:
-		return "generic"
+func selectKernel(name string) {
+	useSIMDDecode = name != "generic"
+	useSIMDEncode = useSIMDDecode
+	if useSIMDDecode {
+		setSIMDKernel(name)
+	}
+	kernelTested.Store(!useSIMDDecode)
+}
+
+// checkKernel self-tests the selected kernel the first time it is used.
+func checkKernel() {
+	if kernelTested.Load() {
+		return
+	}
+	kernelMu.Lock()
+	defer kernelMu.Unlock()
+	if !kernelTested.Load() {
+		testKernel()
+	}
+}
+
+// selfTestCRC is the CRC32 of selfTestInput encoded with 128-byte lines.
+var selfTestCRC uint32 = 0x1b3f3eb4
+
+// selfTestInput returns data with bytes needing escapes in every position of
+// a line and of a SIMD chunk.
+func selfTestInput() []byte {
+	escaped := []byte{0xd6, 0xe3, 0xe0, 0x13, 0xdf, 0xf6, 0x04} // Encode to NUL, \r, \n, =, tab, space and .
+	src := make([]byte, 1000)
+	for i := range src {
+		if i%5 == 0 {
+			src[i] = escaped[i/5%len(escaped)]
+		} else {
+			src[i] = byte(i * 7)
+		}
+	}
+	return src
+}
+
+// testKernel checks that the selected kernel encodes and decodes a known
+// input to the known answer, selecting the generic kernel if it doesn't.
+// kernelMu must be held.
+func testKernel() bool {
+	src := selfTestInput()
+	encoded := make([]byte, MaxLength(len(src), 128)+2)
+	n, _ := encodeGeneric(128, src, encoded, 0)
+	encoded = append(encoded[:n], "\r\n"...)
+
+	decoded := make([]byte, len(encoded))
+	var state State
+	nd, _, lines, _ := decodeGeneric(decoded, encoded, &state)
+
+	ok := crc32.ChecksumIEEE(encoded[:n]) == selfTestCRC && bytes.Equal(decoded[:nd], src) &&
+		lines == bytes.Count(encoded, []byte("\n"))
+	if !ok {
+		selectKernel("generic")
 	}
+	kernelTested.Store(true)
+	return ok
 }
@@ -0,0 +1,32 @@
+//go:build amd64 && !purego
+
+package rapidyenc
+
+import "golang.org/x/sys/cpu"
+
+// hasAVX2 reports whether the CPU and OS support the AVX2 kernels, which
+// also use POPCNT.
+var hasAVX2 = cpu.X86.HasAVX2 && cpu.X86.HasPOPCNT
+
+// useAVX2 selects the 32-byte AVX2 kernels over the 16-byte SSE2 ones.
+var useAVX2 = hasAVX2
+
+// simdKernels returns the SIMD kernels supported by this CPU.
+func simdKernels() []string {
+	if hasAVX2 {
+		return []string{"SSE2", "AVX2"}
+	}
+	return []string{"SSE2"}
+}
+
+// setSIMDKernel selects one of simdKernels for both decoding and encoding.
+func setSIMDKernel(name string) {
+	useAVX2 = name == "AVX2"
+}
+
+func archKernel() string {
+	if useAVX2 {
+		return "AVX2"
+	}
+	return "SSE2"
+}
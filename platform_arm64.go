@@ -0,0 +1,15 @@
+//go:build arm64 && !purego
+
+package rapidyenc
+
+// simdKernels returns the SIMD kernels supported by this CPU.
+func simdKernels() []string {
+	return []string{"NEON"}
+}
+
+// setSIMDKernel selects one of simdKernels for both decoding and encoding.
+func setSIMDKernel(name string) {}
+
+func archKernel() string {
+	return "NEON"
+}
@@ -0,0 +1,15 @@
+//go:build purego || !(amd64 || arm64)
+
+package rapidyenc
+
+// simdKernels returns the SIMD kernels supported by this CPU.
+func simdKernels() []string {
+	return nil
+}
+
+// setSIMDKernel selects one of simdKernels for both decoding and encoding.
+func setSIMDKernel(name string) {}
+
+func archKernel() string {
+	return "generic"
+}
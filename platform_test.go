@@ -2,6 +2,7 @@ package rapidyenc
 
 import (
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"testing"
 )
 
@@ -16,3 +17,116 @@ func TestDecodeKernel(t *testing.T) {
 func TestEncodeKernel(t *testing.T) {
 	assert.NotEqual(t, EncodeKernel(), "unknown")
 }
+
+func TestSetKernel(t *testing.T) {
+	old := DecodeKernel()
+	defer func() { require.NoError(t, SetKernel(old)) }()
+
+	kernels := Kernels()
+	require.Equal(t, "generic", kernels[0])
+	require.Contains(t, kernels, old)
+
+	src := selfTestInput()
+	var want []byte
+	for _, kernel := range kernels {
+		require.NoError(t, SetKernel(kernel))
+		assert.Equal(t, kernel, DecodeKernel())
+		assert.Equal(t, kernel, EncodeKernel())
+
+		encoded, err := Encode(src)
+		require.NoError(t, err)
+		if want == nil {
+			want = encoded
+		}
+		require.Equal(t, want, encoded, kernel)
+	}
+
+	err := SetKernel("MMX")
+	require.ErrorIs(t, err, ErrUnknownKernel)
+	require.Equal(t, kernels[len(kernels)-1], DecodeKernel())
+}
+
+func TestKernelFromEnv(t *testing.T) {
+	old := DecodeKernel()
+	defer func() { require.NoError(t, SetKernel(old)) }()
+
+	t.Setenv(KernelEnv, "generic")
+	kernelFromEnv()
+	require.Equal(t, "generic", DecodeKernel())
+	require.Equal(t, "generic", EncodeKernel())
+
+	t.Setenv(KernelEnv, "MMX")
+	kernelFromEnv()
+	require.Equal(t, "generic", DecodeKernel())
+}
+
+func TestKernelSelfTest(t *testing.T) {
+	old := DecodeKernel()
+	defer func() { require.NoError(t, SetKernel(old)) }()
+
+	for _, kernel := range Kernels() {
+		selectKernel(kernel)
+		require.Equal(t, kernel == "generic", kernelTested.Load(), kernel)
+		require.Equal(t, kernel, DecodeKernel(), "self-test of %s failed", kernel)
+		require.True(t, kernelTested.Load(), kernel)
+	}
+
+	// A kernel giving the wrong answer falls back to generic
+	selfTestCRC ^= 1
+	defer func() { selfTestCRC ^= 1 }()
+	for _, kernel := range simdKernels() {
+		selectKernel(kernel)
+		require.Equal(t, "generic", EncodeKernel(), kernel)
+		require.ErrorIs(t, SetKernel(kernel), ErrKernelSelfTest)
+		require.Equal(t, "generic", DecodeKernel(), kernel)
+	}
+}
+
+// forEachSIMDKernel runs f as a subtest for each SIMD kernel the host
+// supports, with that kernel selected by SetKernel. The kernel in use before
+// is selected again once done, even when the tests flip useSIMDDecode or
+// useSIMDEncode.
+func forEachSIMDKernel(t *testing.T, f func(t *testing.T)) {
+	old := DecodeKernel()
+	defer func() { require.NoError(t, SetKernel(old)) }()
+	for _, kernel := range simdKernels() {
+		t.Run(kernel, func(t *testing.T) {
+			require.NoError(t, SetKernel(kernel))
+			f(t)
+		})
+	}
+}
+
+// benchSIMDKernels runs f as a sub-benchmark for each SIMD kernel the host
+// supports, with that kernel selected by SetKernel, or once without one.
+func benchSIMDKernels(b *testing.B, f func(b *testing.B)) {
+	kernels := simdKernels()
+	if len(kernels) == 0 {
+		f(b)
+		return
+	}
+	old := DecodeKernel()
+	defer func() { require.NoError(b, SetKernel(old)) }()
+	for _, kernel := range kernels {
+		b.Run(kernel, func(b *testing.B) {
+			require.NoError(b, SetKernel(kernel))
+			f(b)
+		})
+	}
+}
+
+func TestForEachSIMDKernel(t *testing.T) {
+	old := DecodeKernel()
+	defer func() { require.NoError(t, SetKernel(old)) }()
+
+	// The kernels are run even when generic is selected, as by KernelEnv
+	require.NoError(t, SetKernel("generic"))
+	var ran []string
+	forEachSIMDKernel(t, func(t *testing.T) {
+		require.Equal(t, DecodeKernel(), EncodeKernel())
+		ran = append(ran, DecodeKernel())
+	})
+	require.Equal(t, simdKernels(), ran)
+	require.Equal(t, "generic", DecodeKernel())
+	require.Equal(t, "generic", EncodeKernel())
+}
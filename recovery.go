@@ -0,0 +1,197 @@
+package rapidyenc
+
+import (
+	"bytes"
+	"io"
+)
+
+// Range is a half-open range [Start, End) of offsets within the decoded file.
+type Range struct {
+	Start int64
+	End   int64
+}
+
+// Len returns the number of bytes in the range.
+func (r Range) Len() int64 {
+	return r.End - r.Start
+}
+
+// RecoveryReport describes the data salvaged by a [Decoder] in recovery mode.
+//
+// Every decoded line is classified by its structure: lines that contain NUL
+// bytes, that are longer than "=ybegin line=", or that are shorter but not
+// the last line of the part are suspect. The CRC32 of the part can only be
+// checked as a whole, Verified reports whether it matched.
+type RecoveryReport struct {
+	Confident []Range // Decoded ranges from well formed lines
+	Suspect   []Range // Decoded ranges from damaged lines
+
+	SkippedLines int  // Garbage lines that were not decoded
+	Verified     bool // The decoded data matched the "=yend" size and pcrc32
+
+	// Shortfall is the size given by "=ypart begin= end=" (or "=ybegin size=" for
+	// single-part articles) less the decoded size. It is negative when more data
+	// was decoded than expected.
+	Shortfall int64
+}
+
+// WithRecovery enables recovery mode, for salvaging data from damaged articles.
+//
+// Garbage lines within the body are skipped and decoding resumes on the next
+// line, rather than the rest of the body being ignored. When the "=yend"
+// trailer is missing, a repeated "=ybegin" or "=ypart" header ends the part.
+// Errors are still reported, [Decoder.Report] describes what was decoded.
+func WithRecovery() DecoderOption {
+	return func(d *Decoder) {
+		d.recover = true
+	}
+}
+
+// Report returns the [RecoveryReport] of a [Decoder] in recovery mode, it is
+// complete once Read has returned an error.
+func (d *Decoder) Report() RecoveryReport {
+	return d.report
+}
+
+// recoveryLine tracks the body line being decoded in recovery mode.
+type recoveryLine struct {
+	encoded int  // Encoded bytes, including the line ending
+	eol     int  // Length of the line ending, 2 for CRLF or 1 for a bare LF
+	decoded int  // Decoded bytes
+	nul     bool // Contains a NUL byte, which yEnc always escapes
+
+	short    Range // A line shorter than "=ybegin line=", suspect unless it is the last
+	hasShort bool
+}
+
+// lineWindow limits src to the end of the current body line. It returns the
+// structure of the line before src is overwritten by decoding in place.
+func (d *Decoder) lineWindow(src []byte) (window []byte, lf, nul int) {
+	lf = bytes.IndexByte(src, '\n')
+	if lf != -1 {
+		src = src[:lf+1]
+		// A \r ending the previous window leaves the decoder in StateCR
+		d.line.eol = 1
+		if (lf > 0 && src[lf-1] == '\r') || (lf == 0 && d.State == StateCR) {
+			d.line.eol = 2
+		}
+	}
+	return src, lf, bytes.IndexByte(src, 0)
+}
+
+// trackLine records decoding of the first ns bytes of a window from
+// lineWindow into nd bytes of the current body line.
+func (d *Decoder) trackLine(ns, nd, lf, nul int) {
+	l := &d.line
+	l.encoded += ns
+	l.decoded += nd
+	l.nul = l.nul || (nul != -1 && nul < ns)
+
+	if lf != -1 && ns == lf+1 {
+		d.finishLine()
+	}
+}
+
+// finishLine classifies the decoded range of a complete body line.
+func (d *Decoder) finishLine() {
+	l := &d.line
+	end := d.Meta.Offset + d.actualSize
+	r := Range{Start: end - int64(l.decoded), End: end}
+	length := int64(l.encoded - l.eol)
+
+	d.flushShort(false)
+
+	switch {
+	case r.Len() == 0:
+	case l.nul:
+		addRange(&d.report.Suspect, r)
+	case !d.Meta.HasLineLength:
+		addRange(&d.report.Confident, r)
+	case length > d.Meta.LineLength+2:
+		// An escape at the end of the line and dot-stuffing may each add one
+		addRange(&d.report.Suspect, r)
+	case length < d.Meta.LineLength-1:
+		l.short = r
+		l.hasShort = true
+	default:
+		addRange(&d.report.Confident, r)
+	}
+
+	l.encoded, l.decoded, l.nul = 0, 0, false
+}
+
+// flushShort classifies a pending short line, it is only confident as the last line of the part.
+func (d *Decoder) flushShort(last bool) {
+	l := &d.line
+	if !l.hasShort {
+		return
+	}
+	l.hasShort = false
+
+	if last {
+		addRange(&d.report.Confident, l.short)
+	} else {
+		addRange(&d.report.Suspect, l.short)
+	}
+}
+
+// skipLine reports whether a line outside the body is garbage that recovery
+// mode skips. Decoding of the body resumes after a garbage line.
+func (d *Decoder) skipLine(line []byte) bool {
+	if d.skipRest {
+		return true
+	}
+	if !d.begin || d.end || (!d.part && !d.Meta.SinglePart) {
+		return false
+	}
+
+	switch kind, _ := cutHeaderKind(line); kind {
+	case HeaderEnd:
+		return false
+	case HeaderBegin, HeaderPart:
+		if kind == HeaderPart && !d.part {
+			return false
+		}
+		// A repeated header means the "=yend" trailer is missing
+		d.skipRest = true
+		d.body = false
+	default:
+		d.body = true
+		d.State = StateCRLF
+	}
+	return true
+}
+
+// finishRecovery completes the report at the end of the article, err is the result of verify.
+func (d *Decoder) finishRecovery(err error) {
+	if !d.recover || d.format != FormatYenc {
+		return
+	}
+
+	if l := &d.line; l.encoded > 0 {
+		// Truncated in the middle of a line
+		d.flushShort(false)
+		end := d.Meta.Offset + d.actualSize
+		if l.decoded > 0 {
+			addRange(&d.report.Suspect, Range{Start: end - int64(l.decoded), End: end})
+		}
+		l.encoded, l.decoded, l.nul = 0, 0, false
+	}
+	d.flushShort(d.end)
+
+	expected := d.Meta.PartSize
+	if d.sizeKnown {
+		expected = d.partSize
+	}
+	d.report.Shortfall = expected - d.actualSize
+	d.report.Verified = d.end && err == io.EOF
+}
+
+// addRange appends r to ranges, merging it with the last range when they are adjacent.
+func addRange(ranges *[]Range, r Range) {
+	if n := len(*ranges); n > 0 && (*ranges)[n-1].End == r.Start {
+		(*ranges)[n-1].End = r.End
+		return
+	}
+	*ranges = append(*ranges, r)
+}
@@ -0,0 +1,177 @@
+package rapidyenc
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recoveryArticle returns a yEnc encoded part at offset 1000 of a larger file.
+// The data is letters, which are never escaped, so each line holds 128 bytes.
+func recoveryArticle(t *testing.T, size int) (raw, encoded []byte) {
+	raw = make([]byte, size)
+	for i := range raw {
+		raw[i] = 'a' + byte(i%26)
+	}
+	return raw, encodeRecoveryArticle(t, raw)
+}
+
+func encodeRecoveryArticle(t *testing.T, raw []byte) []byte {
+	size := len(raw)
+
+	w := new(bytes.Buffer)
+	enc, err := NewEncoder(w, Meta{
+		FileName:   "recovery.bin",
+		FileSize:   100_000,
+		Offset:     1000,
+		PartSize:   int64(size),
+		PartNumber: 2,
+		TotalParts: 10,
+	})
+	require.NoError(t, err)
+	_, err = enc.Write(raw)
+	require.NoError(t, err)
+	require.NoError(t, enc.Close())
+
+	return w.Bytes()
+}
+
+// decodeRecovery decodes encoded in recovery mode, with both Decoder and DecodeWriter.
+func decodeRecovery(t *testing.T, encoded []byte) ([]byte, RecoveryReport, error) {
+	dec := NewDecoder(iotest.HalfReader(bytes.NewReader(encoded)), WithRecovery())
+	decoded, err := io.ReadAll(dec)
+
+	w := NewDecodeWriter(io.Discard, WithRecovery())
+	for _, b := range encoded {
+		_, _ = w.Write([]byte{b})
+	}
+	_ = w.Close()
+	require.Equal(t, dec.Report(), w.Report())
+
+	return decoded, dec.Report(), err
+}
+
+func TestRecoveryValid(t *testing.T) {
+	raw, encoded := recoveryArticle(t, 10_000)
+
+	random := make([]byte, 10_000)
+	_, err := rand.Read(random)
+	require.NoError(t, err)
+
+	for _, tc := range []struct{ raw, encoded []byte }{
+		{raw, encoded},
+		{random, encodeRecoveryArticle(t, random)},
+	} {
+		decoded, report, err := decodeRecovery(t, tc.encoded)
+		require.NoError(t, err)
+		require.Equal(t, tc.raw, decoded)
+		require.Equal(t, RecoveryReport{
+			Confident: []Range{{Start: 1000, End: 11_000}},
+			Verified:  true,
+		}, report)
+	}
+}
+
+func TestRecoveryTruncated(t *testing.T) {
+	raw, encoded := recoveryArticle(t, 10_000)
+
+	// Cut the article in the middle of the 11th body line
+	lines := bytes.SplitAfter(encoded, []byte("\r\n"))
+	var truncated []byte
+	for _, line := range lines[:12] {
+		truncated = append(truncated, line...)
+	}
+	truncated = append(truncated, lines[12][:50]...)
+
+	decoded, report, err := decodeRecovery(t, truncated)
+	require.Error(t, err)
+	require.Equal(t, raw[:len(decoded)], decoded)
+	require.False(t, report.Verified)
+	require.Len(t, decoded, 10*128+50)
+	require.Equal(t, RecoveryReport{
+		Confident: []Range{{Start: 1000, End: 1000 + 10*128}},
+		Suspect:   []Range{{Start: 1000 + 10*128, End: 1000 + 10*128 + 50}},
+		Shortfall: int64(len(raw) - len(decoded)),
+	}, report)
+}
+
+func TestRecoveryGarbageControlLine(t *testing.T) {
+	raw, encoded := recoveryArticle(t, 10_000)
+
+	lines := bytes.SplitAfter(encoded, []byte("\r\n"))
+	garbage := bytes.Join(append(lines[:10:10], append([][]byte{[]byte("=yjunk foo=bar\r\n")}, lines[10:]...)...), nil)
+
+	// Without recovery the rest of the body is lost
+	_, err := io.ReadAll(NewDecoder(bytes.NewReader(garbage)))
+	require.ErrorIs(t, err, ErrDataCorruption)
+
+	decoded, report, err := decodeRecovery(t, garbage)
+	require.NoError(t, err)
+	require.Equal(t, raw, decoded)
+	require.Equal(t, RecoveryReport{
+		Confident:    []Range{{Start: 1000, End: 11_000}},
+		SkippedLines: 1,
+		Verified:     true,
+	}, report)
+}
+
+func TestRecoveryGarbageLine(t *testing.T) {
+	_, encoded := recoveryArticle(t, 10_000)
+
+	lines := bytes.SplitAfter(encoded, []byte("\r\n"))
+	garbage := bytes.Join(append(lines[:10:10], append([][]byte{[]byte("garbage\r\n")}, lines[10:]...)...), nil)
+
+	decoded, report, err := decodeRecovery(t, garbage)
+	require.ErrorIs(t, err, ErrDataCorruption)
+	require.Len(t, decoded, 10_000+len("garbage"))
+	require.Equal(t, RecoveryReport{
+		Confident: []Range{{Start: 1000, End: 1000 + 8*128}, {Start: 1000 + 8*128 + 7, End: 11_007}},
+		Suspect:   []Range{{Start: 1000 + 8*128, End: 1000 + 8*128 + 7}},
+		Shortfall: int64(-len("garbage")),
+	}, report)
+}
+
+func TestRecoveryMissingTrailer(t *testing.T) {
+	raw, encoded := recoveryArticle(t, 10_000)
+	_, next := recoveryArticle(t, 500)
+
+	end := bytes.LastIndex(encoded, []byte("=yend"))
+	article := append(encoded[:end:end], next...)
+	article = append(article, ".\r\n"...)
+
+	decoded, report, err := decodeRecovery(t, article)
+	require.ErrorIs(t, err, ErrDataCorruption)
+	require.Equal(t, raw, decoded)
+	require.Equal(t, RecoveryReport{
+		Confident:    []Range{{Start: 1000, End: 1000 + 78*128}},
+		Suspect:      []Range{{Start: 1000 + 78*128, End: 11_000}},
+		SkippedLines: 7,
+	}, report)
+}
+
+func TestRecoveryLongLineLF(t *testing.T) {
+	raw, encoded := recoveryArticle(t, 10_000)
+
+	// Move 3 bytes of the 10th body line to the end of the 9th, beyond what escaping and dot-stuffing add
+	lines := bytes.SplitAfter(encoded, []byte("\r\n"))
+	lines[10] = append(append(lines[10][:128:128], lines[11][:3]...), "\r\n"...)
+	lines[11] = lines[11][3:]
+	crlf := bytes.Join(lines, nil)
+	lf := bytes.ReplaceAll(crlf, []byte("\r\n"), []byte("\n"))
+
+	want := RecoveryReport{
+		Confident: []Range{{Start: 1000, End: 1000 + 8*128}, {Start: 1000 + 10*128, End: 11_000}},
+		Suspect:   []Range{{Start: 1000 + 8*128, End: 1000 + 10*128}},
+		Verified:  true,
+	}
+	for _, article := range [][]byte{crlf, lf} {
+		decoded, report, err := decodeRecovery(t, article)
+		require.NoError(t, err)
+		require.Equal(t, raw, decoded)
+		require.Equal(t, want, report)
+	}
+}
@@ -3,8 +3,12 @@ package rapidyenc
 import (
 	"bytes"
 	"crypto/rand"
+	"fmt"
+	"hash/crc32"
 	"io"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/stretchr/testify/require"
 )
@@ -103,14 +107,9 @@ func TestEncodeDecodeRoundTripSIMDDecodeOnly(t *testing.T) {
 
 func TestEncodeDecodeRoundTripNoSIMD(t *testing.T) {
 	// Temporarily disable SIMD
-	oldDecode := useSIMDDecode
-	oldEncode := useSIMDEncode
-	useSIMDDecode = false
-	useSIMDEncode = false
-	defer func() {
-		useSIMDDecode = oldDecode
-		useSIMDEncode = oldEncode
-	}()
+	old := DecodeKernel()
+	require.NoError(t, SetKernel("generic"))
+	defer func() { require.NoError(t, SetKernel(old)) }()
 
 	raw := make([]byte, 1024*1024)
 	_, err := rand.Read(raw)
@@ -139,6 +138,51 @@ func TestEncodeDecodeRoundTripNoSIMD(t *testing.T) {
 	require.Equal(t, int64(len(raw)), n)
 }
 
+func TestSinglePartRoundTrip(t *testing.T) {
+	for _, size := range []int{1, 127, 128, 129, 100_000} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			raw := make([]byte, size)
+			_, err := rand.Read(raw)
+			require.NoError(t, err)
+
+			meta := Meta{
+				SinglePart: true,
+				FileName:   "single part.bin",
+				FileSize:   int64(len(raw)),
+				PartSize:   int64(len(raw)),
+			}
+
+			w := new(bytes.Buffer)
+			enc, err := NewEncoder(w, meta)
+			require.NoError(t, err)
+			_, err = io.Copy(enc, bytes.NewReader(raw))
+			require.NoError(t, err)
+			require.NoError(t, enc.Close())
+
+			encoded := w.Bytes()
+			require.True(t, bytes.HasPrefix(encoded, fmt.Appendf(nil, "=ybegin line=128 size=%d name=single part.bin\r\n", size)))
+			require.True(t, bytes.HasSuffix(encoded, fmt.Appendf(nil, "\r\n=yend size=%d crc32=%08x\r\n", size, crc32.ChecksumIEEE(raw))))
+			require.NotContains(t, string(encoded), "=ypart")
+
+			dec := NewDecoder(bytes.NewReader(encoded))
+			decoded := new(bytes.Buffer)
+			_, err = io.Copy(decoded, dec)
+			require.NoError(t, err)
+			require.Equal(t, raw, decoded.Bytes())
+			require.True(t, dec.Meta.SinglePart)
+			require.Equal(t, meta.FileName, dec.Meta.FileName)
+			require.Equal(t, meta.FileSize, dec.Meta.FileSize)
+			require.Equal(t, meta.PartSize, dec.Meta.PartSize)
+			require.Equal(t, crc32.ChecksumIEEE(raw), dec.Meta.Hash)
+
+			// Corrupt the whole file crc32 to check it is verified
+			corrupt := bytes.Replace(encoded, fmt.Appendf(nil, "crc32=%08x", crc32.ChecksumIEEE(raw)), []byte("crc32=00000000"), 1)
+			_, err = io.Copy(io.Discard, NewDecoder(bytes.NewReader(corrupt)))
+			require.ErrorIs(t, err, ErrCrcMismatch)
+		})
+	}
+}
+
 func TestRawEncodeDecodeRoundTrip(t *testing.T) {
 	raw := make([]byte, 1024*1024)
 	_, err := rand.Read(raw)
@@ -155,7 +199,7 @@ func TestRawEncodeDecodeRoundTrip(t *testing.T) {
 
 	decoded := make([]byte, len(encoded))
 	var state State
-	nDst, nSrc, end := decodeGeneric(decoded, encoded, &state)
+	nDst, nSrc, _, end := decodeGeneric(decoded, encoded, &state)
 	t.Logf("Decoded: %d, Consumed: %d, End: %d", nDst, nSrc, end)
 
 	if nDst != len(raw) {
@@ -188,7 +232,7 @@ func TestRawEncodeDecodeSmall(t *testing.T) {
 
 		decoded := make([]byte, len(encoded))
 		var state State
-		nDst, _, end := decodeGeneric(decoded, encoded, &state)
+		nDst, _, _, end := decodeGeneric(decoded, encoded, &state)
 
 		if end != EndControl {
 			t.Errorf("size=%d: expected EndControl, got %d", size, end)
@@ -201,3 +245,108 @@ func TestRawEncodeDecodeSmall(t *testing.T) {
 		}
 	}
 }
+
+func TestRawRoundTrip(t *testing.T) {
+	random := make([]byte, 100_000)
+	_, err := rand.Read(random)
+	require.NoError(t, err)
+
+	for _, raw := range [][]byte{nil, []byte(" "), []byte(".foo\tbar "), random} {
+		w := new(bytes.Buffer)
+		enc, err := NewEncoder(w, Meta{Raw: true})
+		require.NoError(t, err)
+		_, err = enc.Write(raw)
+		require.NoError(t, err)
+		require.NoError(t, enc.Close())
+		encoded := w.Bytes()
+
+		for _, terminator := range []string{"", "\r\n=yend size=1\r\n", "\r\n.\r\n"} {
+			article := append(bytes.Clone(encoded), terminator...)
+
+			dec := NewDecoder(iotest.HalfReader(bytes.NewReader(article)), WithRaw())
+			decoded, err := io.ReadAll(dec)
+			require.NoError(t, err)
+			require.Equal(t, string(raw), string(decoded))
+			require.True(t, dec.Meta.Raw)
+			require.Equal(t, int64(len(raw)), dec.Meta.PartSize)
+			require.Equal(t, crc32.ChecksumIEEE(raw), dec.Meta.Hash)
+
+			out := new(bytes.Buffer)
+			dw := NewDecodeWriter(out, WithRaw())
+			_, err = dw.Write(article)
+			require.NoError(t, err)
+			require.NoError(t, dw.Close())
+			require.Equal(t, string(raw), out.String())
+
+			meta, err := VerifyBytes(article, WithRaw())
+			require.NoError(t, err)
+			require.Equal(t, dec.Meta, meta)
+		}
+	}
+}
+
+func TestExtraHeadersRoundTrip(t *testing.T) {
+	raw := []byte("hello world")
+	meta := Meta{
+		FileName:   "extra.bin",
+		FileSize:   int64(len(raw)),
+		PartSize:   int64(len(raw)),
+		PartNumber: 1,
+		TotalParts: 1,
+	}
+	extra := []HeaderKeyValue{
+		{Header: HeaderBegin, Key: "rar", Value: "1"},
+		{Header: HeaderPart, Key: "tag", Value: "x"},
+		{Header: HeaderEnd, Key: "hash", Value: "abc"},
+		{Header: HeaderEnd, Key: "crc", Value: "0"},
+	}
+
+	w := new(bytes.Buffer)
+	enc, err := NewEncoder(w, meta, WithExtra(extra...))
+	require.NoError(t, err)
+	_, err = enc.Write(raw)
+	require.NoError(t, err)
+	require.NoError(t, enc.Close())
+
+	encoded := w.String()
+	require.Contains(t, encoded, "=ybegin part=1 total=1 line=128 size=11 rar=1 name=extra.bin\r\n=ypart begin=1 end=11 tag=x\r\n")
+	require.Contains(t, encoded, " hash=abc crc=0\r\n")
+
+	dec := NewDecoder(strings.NewReader(encoded))
+	decoded, err := io.ReadAll(dec)
+	require.NoError(t, err)
+	require.Equal(t, raw, decoded)
+	require.Equal(t, extra, dec.Meta.Extra)
+
+	lines := strings.SplitAfter(encoded, "\r\n")
+	require.Equal(t, lines[0]+lines[1]+lines[len(lines)-2], dec.RawHeaders())
+
+	// Re-encoding the decoded meta reproduces the article
+	w.Reset()
+	enc, err = NewEncoder(w, dec.Meta.Meta, WithExtra(dec.Meta.Extra...))
+	require.NoError(t, err)
+	_, err = enc.Write(decoded)
+	require.NoError(t, err)
+	require.NoError(t, enc.Close())
+	require.Equal(t, encoded, w.String())
+}
+
+func TestDecodeExtraHeaders(t *testing.T) {
+	article := "=ybegin part=1 total=1 line=128 size=3 x-poster=me\tsize=4 name=a b=c\r\n" +
+		"=ypart begin=1 end=3\r\n" +
+		"\x8b\x8c\x8d\r\n" +
+		"=yend size=3 part=1 pcrc32=352441c2 rar=1\r\n"
+
+	dec := NewDecoder(strings.NewReader(article))
+	decoded, err := io.ReadAll(dec)
+	require.NoError(t, err)
+	require.Equal(t, "abc", string(decoded))
+	require.Equal(t, "a b=c", dec.Meta.FileName)
+	require.Equal(t, int64(3), dec.Meta.FileSize)
+	require.Equal(t, []HeaderKeyValue{
+		{Header: HeaderBegin, Key: "x-poster", Value: "me"},
+		{Header: HeaderBegin, Key: "size", Value: "4"}, // duplicates are kept
+		{Header: HeaderEnd, Key: "rar", Value: "1"},
+	}, dec.Meta.Extra)
+	require.Equal(t, strings.Replace(article, "\x8b\x8c\x8d\r\n", "", 1), dec.RawHeaders())
+}
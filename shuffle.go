@@ -0,0 +1,49 @@
+//go:build (amd64 || arm64) && !purego
+
+package rapidyenc
+
+// decodeShuffle maps a mask of lanes to drop from 8 bytes to the byte shuffle
+// indices gathering the kept lanes at the start, for PSHUFB in decodeAVX2 and
+// TBL in the NEON decodeFast. Unused lanes get 0x80, which both zero.
+var decodeShuffle [256]uint64
+
+func init() {
+	for m := range decodeShuffle {
+		var idx uint64
+		n := 0
+		for lane := range 8 {
+			if m&(1<<lane) == 0 {
+				idx |= uint64(lane) << (8 * n)
+				n++
+			}
+		}
+		for ; n < 8; n++ {
+			idx |= 0x80 << (8 * n)
+		}
+		decodeShuffle[m] = idx
+	}
+}
+
+// encodeExpand maps a mask of lanes to escape from 8 bytes to 32 bytes for
+// encodeAVX2 and the NEON encodeFast: the byte shuffle indices repeating each
+// escaped lane, then 0xFF where the "=" goes.
+var encodeExpand [256][32]byte
+
+func init() {
+	for m := range encodeExpand {
+		e := &encodeExpand[m]
+		n := 0
+		for lane := range 8 {
+			if m&(1<<lane) != 0 {
+				e[n] = byte(lane)
+				e[16+n] = 0xFF
+				n++
+			}
+			e[n] = byte(lane)
+			n++
+		}
+		for ; n < 16; n++ {
+			e[n] = 0x80
+		}
+	}
+}
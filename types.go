@@ -18,6 +18,7 @@ const (
 )
 
 // End is the state for incremental decoding, whether the end of the yEnc data was reached.
+// A bare \n is accepted in place of each \r\n below.
 type End int
 
 const (
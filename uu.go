@@ -0,0 +1,90 @@
+package rapidyenc
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// uuChar returns the 6-bit value of a uuencoded character.
+// Both ' ' and '`' map to zero as encoders use either for padding.
+func uuChar(c byte) byte {
+	return (c - ' ') & 0x3f
+}
+
+// parseUUBegin extracts the file name from a "begin <mode> <name>" line.
+func parseUUBegin(line []byte) (string, bool) {
+	rest, found := bytes.CutPrefix(line, []byte("begin "))
+	if !found {
+		return "", false
+	}
+
+	rest = bytes.TrimLeft(rest, " ")
+	mode, name, _ := bytes.Cut(rest, []byte(" "))
+	if len(mode) == 0 {
+		return "", false
+	}
+	for _, c := range mode {
+		if c < '0' || c > '7' {
+			return "", false
+		}
+	}
+
+	return string(bytes.TrimSpace(name)), true
+}
+
+// isUUBeginLine reports whether line would be handled as the "begin" header,
+// which is only looked for until one has been found.
+func isUUBeginLine(line []byte, begin bool) bool {
+	return !begin && bytes.HasPrefix(line, []byte("begin "))
+}
+
+// isUUEnd reports whether line is the "end" trailer of a uuencoded file.
+func isUUEnd(line []byte) bool {
+	return bytes.Equal(bytes.TrimRight(line, " \t"), []byte("end"))
+}
+
+// uudecodeLine decodes a single length-prefixed uuencoded line into dst.
+// The decoded length never exceeds len(line), so dst may alias line.
+// Trailing padding that was stripped in transit is treated as zero.
+func uudecodeLine(dst, line []byte) (int, error) {
+	if len(line) == 0 {
+		return 0, nil
+	}
+
+	for _, c := range line {
+		if c < ' ' || c > '`' {
+			return 0, fmt.Errorf("[rapidyenc] invalid character %#02x in uuencoded line: %w", c, ErrDataCorruption)
+		}
+	}
+
+	n := int(uuChar(line[0]))
+	src := line[1:]
+	if n > len(line) {
+		return 0, fmt.Errorf("[rapidyenc] uuencoded line length %d exceeds line: %w", n, ErrDataCorruption)
+	}
+
+	var quad [4]byte
+	p := 0
+	for i := 0; p < n; i += 4 {
+		for j := range quad {
+			if i+j < len(src) {
+				quad[j] = uuChar(src[i+j])
+			} else {
+				quad[j] = 0
+			}
+		}
+
+		dst[p] = quad[0]<<2 | quad[1]>>4
+		p++
+		if p < n {
+			dst[p] = quad[1]<<4 | quad[2]>>2
+			p++
+		}
+		if p < n {
+			dst[p] = quad[2]<<6 | quad[3]
+			p++
+		}
+	}
+
+	return p, nil
+}
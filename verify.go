@@ -0,0 +1,62 @@
+package rapidyenc
+
+import (
+	"io"
+	"sync"
+)
+
+// verifyScratchSize is the size of the area VerifyBytes decodes into, small
+// enough that the decoded data is still in cache when it is hashed.
+const verifyScratchSize = 8 * 1024
+
+var verifyScratchPool = sync.Pool{
+	New: func() any {
+		return new([verifyScratchSize]byte)
+	},
+}
+
+// Verify decodes the article read from r and checks it against its headers,
+// without returning the decoded data. The data is decoded in place within the
+// [Decoder]'s internal buffer, so no output buffer is needed.
+//
+// The error is nil for a valid article, otherwise it is the error [Decoder.Read] reports.
+func Verify(r io.Reader, opts ...DecoderOption) (DecodedMeta, error) {
+	d := AcquireDecoder(r, opts...)
+	defer ReleaseDecoder(d)
+
+	for {
+		if _, err := d.next(nil); err != nil {
+			return d.Meta, verifyResult(err)
+		}
+	}
+}
+
+// VerifyBytes is [Verify] for an article held in memory. src is not modified,
+// it is decoded into a small scratch area that is reused for each chunk.
+func VerifyBytes(src []byte, opts ...DecoderOption) (DecodedMeta, error) {
+	scratch := verifyScratchPool.Get().(*[verifyScratchSize]byte)
+	defer verifyScratchPool.Put(scratch)
+
+	d := AcquireDecoder(nil, opts...)
+	buf := d.buf
+	defer func() {
+		d.buf = buf
+		ReleaseDecoder(d)
+	}()
+
+	// Decode straight from src, there is nothing more to read
+	d.buf, d.wpos, d.err = src, len(src), io.EOF
+
+	for {
+		if _, err := d.next(scratch[:]); err != nil {
+			return d.Meta, verifyResult(err)
+		}
+	}
+}
+
+func verifyResult(err error) error {
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
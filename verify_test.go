@@ -0,0 +1,103 @@
+package rapidyenc
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+	raw := make([]byte, 100_000)
+	_, err := rand.Read(raw)
+	require.NoError(t, err)
+
+	valid, err := body(raw)
+	require.NoError(t, err)
+	encoded, err := io.ReadAll(valid)
+	require.NoError(t, err)
+
+	uu, err := os.ReadFile("testdata/logo_full.uu")
+	require.NoError(t, err)
+
+	crc := bytes.LastIndex(encoded, []byte("pcrc32="))
+	corrupt := bytes.Clone(encoded)
+	copy(corrupt[crc+len("pcrc32="):], "00000000")
+
+	cases := []struct {
+		name    string
+		article []byte
+		opts    []DecoderOption
+	}{
+		{"valid", encoded, nil},
+		{"crc mismatch", corrupt, nil},
+		{"truncated", encoded[:len(encoded)/2], nil},
+		{"truncated line", encoded[:50], nil},
+		{"missing ybegin", []byte("foo\r\nbar\r\n.\r\n"), nil},
+		{"uuencoded", uu, nil},
+		{"strict", []byte(article("=ybegin part=2 total=1 line=128 size=3 name=a", "=ypart begin=1 end=3", "=yend size=3 part=2")), []DecoderOption{WithStrictness(Strict)}},
+		{"empty", nil, nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dec := NewDecoder(bytes.NewReader(tc.article), tc.opts...)
+			_, expectedErr := io.Copy(io.Discard, dec)
+
+			meta, err := Verify(bytes.NewReader(tc.article), tc.opts...)
+			require.Equal(t, expectedErr, err)
+			require.Equal(t, dec.Meta, meta)
+
+			meta, err = VerifyBytes(tc.article, tc.opts...)
+			require.Equal(t, expectedErr, err)
+			require.Equal(t, dec.Meta, meta)
+		})
+	}
+}
+
+func TestVerifyBytesReadOnly(t *testing.T) {
+	raw := make([]byte, 100_000)
+	_, err := rand.Read(raw)
+	require.NoError(t, err)
+
+	r, err := body(raw)
+	require.NoError(t, err)
+	encoded, err := io.ReadAll(r)
+	require.NoError(t, err)
+	original := bytes.Clone(encoded)
+
+	meta, err := VerifyBytes(encoded)
+	require.NoError(t, err)
+	require.Equal(t, original, encoded)
+	require.Equal(t, int64(len(raw)), meta.PartSize)
+
+	if raceEnabled {
+		t.Skip("sync.Pool drops items under the race detector")
+	}
+
+	// The file name and raw header strings are the only allocations per article
+	allocs := testing.AllocsPerRun(100, func() {
+		_, _ = VerifyBytes(encoded)
+	})
+	require.LessOrEqual(t, allocs, 2.0)
+}
+
+func BenchmarkVerifyBytes(b *testing.B) {
+	raw := make([]byte, 1024*1024)
+	_, err := rand.Read(raw)
+	require.NoError(b, err)
+
+	r, err := body(raw)
+	require.NoError(b, err)
+	encoded, err := io.ReadAll(r)
+	require.NoError(b, err)
+
+	b.SetBytes(int64(len(encoded)))
+	for b.Loop() {
+		_, err := VerifyBytes(encoded)
+		require.NoError(b, err)
+	}
+}
@@ -0,0 +1,81 @@
+package rapidyenc
+
+// Strictness selects how a [Decoder] handles inconsistent yEnc headers.
+type Strictness int
+
+const (
+	// Lenient decodes articles with inconsistent headers, the problems are
+	// reported by [Decoder.Warnings]. This is the default.
+	Lenient Strictness = iota
+
+	// Strict fails decoding with an error wrapping [ErrInvalidHeader] on the
+	// first inconsistent header.
+	Strict
+)
+
+// DecoderOption configures optional [Decoder] settings.
+type DecoderOption func(d *Decoder)
+
+// WithStrictness sets how inconsistent headers are handled, the default is [Lenient].
+func WithStrictness(s Strictness) DecoderOption {
+	return func(d *Decoder) {
+		d.strictness = s
+	}
+}
+
+// WarningKind is the type of problem found in the yEnc headers.
+type WarningKind int
+
+const (
+	WarnInvalidHeader WarningKind = iota + 1 // Malformed key=value pair, such as a non-numeric size or non-hex CRC
+	WarnPartRange                            // "=ypart" begin and end are out of order or exceed the file size
+	WarnPartNumber                           // "=ybegin part=" is greater than total=
+	WarnPartMismatch                         // "=yend part=" differs from "=ybegin part="
+	WarnSizeMismatch                         // "=yend size=" differs from the size given by "=ypart" or "=ybegin"
+)
+
+func (k WarningKind) String() string {
+	switch k {
+	case WarnInvalidHeader:
+		return "invalid header"
+	case WarnPartRange:
+		return "part range"
+	case WarnPartNumber:
+		return "part number"
+	case WarnPartMismatch:
+		return "part mismatch"
+	case WarnSizeMismatch:
+		return "size mismatch"
+	default:
+		return "unknown"
+	}
+}
+
+// Warning is a problem found in the yEnc headers that [Lenient] decoding tolerated.
+// Err describes the problem and wraps [ErrInvalidHeader].
+type Warning struct {
+	Kind WarningKind
+	Err  error
+}
+
+func (w Warning) Error() string {
+	return w.Err.Error()
+}
+
+func (w Warning) Unwrap() error {
+	return w.Err
+}
+
+// warn records a problem with the headers, in [Strict] mode it is returned instead.
+func (d *Decoder) warn(kind WarningKind, err error) error {
+	if d.strictness == Strict {
+		return err
+	}
+	d.warnings = append(d.warnings, Warning{Kind: kind, Err: err})
+	return nil
+}
+
+// Warnings returns the problems found in the yEnc headers decoded so far.
+func (d *Decoder) Warnings() []Warning {
+	return d.warnings
+}
@@ -0,0 +1,144 @@
+package rapidyenc
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// article returns a yEnc article of "abc" with the given header lines.
+func article(begin, part, end string) string {
+	s := begin + "\r\n"
+	if part != "" {
+		s += part + "\r\n"
+	}
+	return s + "\x8b\x8c\x8d\r\n" + end + "\r\n"
+}
+
+func TestDecoderStrictness(t *testing.T) {
+	cases := []struct {
+		name    string
+		article string
+		kind    WarningKind
+	}{
+		{
+			"part beyond file size",
+			article("=ybegin part=1 total=1 line=128 size=2 name=a", "=ypart begin=1 end=3", "=yend size=3 part=1"),
+			WarnPartRange,
+		},
+		{
+			"begin after end",
+			article("=ybegin part=1 total=1 line=128 size=10 name=a", "=ypart begin=5 end=3", "=yend size=3 part=1"),
+			WarnPartRange,
+		},
+		{
+			"part greater than total",
+			article("=ybegin part=2 total=1 line=128 size=3 name=a", "=ypart begin=1 end=3", "=yend size=3 part=2"),
+			WarnPartNumber,
+		},
+		{
+			"yend part differs",
+			article("=ybegin part=1 total=2 line=128 size=6 name=a", "=ypart begin=1 end=3", "=yend size=3 part=2"),
+			WarnPartMismatch,
+		},
+		{
+			"yend size differs from ypart",
+			article("=ybegin part=1 total=2 line=128 size=6 name=a", "=ypart begin=1 end=4", "=yend size=3 part=1"),
+			WarnSizeMismatch,
+		},
+		{
+			"yend size differs from single part",
+			article("=ybegin line=128 size=3 name=a", "", "=yend size=4"),
+			WarnSizeMismatch,
+		},
+		{
+			"non-hex crc",
+			article("=ybegin part=1 total=1 line=128 size=3 name=a", "=ypart begin=1 end=3", "=yend size=3 part=1 pcrc32=xyz"),
+			WarnInvalidHeader,
+		},
+		{
+			"malformed end",
+			article("=ybegin part=1 total=1 line=128 size=3 name=a", "=ypart begin=1 end=3x", "=yend size=3 part=1"),
+			WarnInvalidHeader,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dec := NewDecoder(strings.NewReader(tc.article))
+			b, err := io.ReadAll(dec)
+			require.NoError(t, err)
+			require.Equal(t, "abc", string(b))
+			require.Len(t, dec.Warnings(), 1)
+			require.Equal(t, tc.kind, dec.Warnings()[0].Kind)
+			require.ErrorIs(t, dec.Warnings()[0], ErrInvalidHeader)
+
+			dec = NewDecoder(strings.NewReader(tc.article), WithStrictness(Strict))
+			_, err = io.ReadAll(dec)
+			require.ErrorIs(t, err, ErrInvalidHeader)
+			require.Empty(t, dec.Warnings())
+
+			// The error is sticky
+			_, err = dec.Read(make([]byte, 100))
+			require.ErrorIs(t, err, ErrInvalidHeader)
+
+			w := NewDecodeWriter(io.Discard, WithStrictness(Strict))
+			_, err = w.Write([]byte(tc.article))
+			if err == nil {
+				err = w.Close()
+			}
+			require.ErrorIs(t, err, ErrInvalidHeader)
+		})
+	}
+}
+
+func TestDecoderStrictValid(t *testing.T) {
+	raw := []byte("hello world")
+
+	w := new(bytes.Buffer)
+	enc, err := NewEncoder(w, Meta{
+		FileName:   "foo",
+		FileSize:   100,
+		PartSize:   int64(len(raw)),
+		Offset:     50,
+		PartNumber: 2,
+		TotalParts: 3,
+	})
+	require.NoError(t, err)
+	_, err = enc.Write(raw)
+	require.NoError(t, err)
+	require.NoError(t, enc.Close())
+
+	dec := NewDecoder(w, WithStrictness(Strict))
+	b, err := io.ReadAll(dec)
+	require.NoError(t, err)
+	require.Equal(t, raw, b)
+	require.Empty(t, dec.Warnings())
+}
+
+func TestDecoderResetKeepsStrictness(t *testing.T) {
+	bad := article("=ybegin part=2 total=1 line=128 size=3 name=a", "=ypart begin=1 end=3", "=yend size=3 part=2")
+
+	dec := NewDecoder(strings.NewReader(bad), WithStrictness(Strict))
+	_, err := io.ReadAll(dec)
+	require.ErrorIs(t, err, ErrInvalidHeader)
+
+	dec.Reset(strings.NewReader(bad))
+	_, err = io.ReadAll(dec)
+	require.ErrorIs(t, err, ErrInvalidHeader)
+
+	dec = AcquireDecoder(strings.NewReader(bad), WithStrictness(Strict))
+	_, err = io.ReadAll(dec)
+	require.ErrorIs(t, err, ErrInvalidHeader)
+	ReleaseDecoder(dec)
+
+	// Released decoders are lenient again
+	dec = AcquireDecoder(strings.NewReader(bad))
+	_, err = io.ReadAll(dec)
+	require.NoError(t, err)
+	require.Len(t, dec.Warnings(), 1)
+	ReleaseDecoder(dec)
+}